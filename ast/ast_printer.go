@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"strings"
 )
 
 type AstPrinter struct {
@@ -36,6 +37,76 @@ func (a *AstPrinter) VisitPrintStmt(stmt *PrintStmt) interface{} {
 	return fmt.Sprintf("print(%v)", a.evaluateExpr(stmt.Value))
 }
 
+func (a *AstPrinter) VisitIfStmt(stmt *IfStmt) interface{} {
+	var out bytes.Buffer
+	out.WriteString(fmt.Sprintf("if %v\n", a.evaluateExpr(stmt.Condition)))
+	out.WriteString(a.printBlock(stmt.ThenBranch))
+	if stmt.ElseBranch != nil {
+		out.WriteString("else\n")
+		out.WriteString(a.printBlock(stmt.ElseBranch))
+	}
+	out.WriteString("endif")
+	return out.String()
+}
+
+func (a *AstPrinter) VisitWhileStmt(stmt *WhileStmt) interface{} {
+	var out bytes.Buffer
+	out.WriteString(fmt.Sprintf("while %v\n", a.evaluateExpr(stmt.Condition)))
+	out.WriteString(a.printBlock(stmt.Body))
+	out.WriteString("endwhile")
+	return out.String()
+}
+
+func (a *AstPrinter) VisitForStmt(stmt *ForStmt) interface{} {
+	var out bytes.Buffer
+	out.WriteString(fmt.Sprintf("for %v = %v to %v", stmt.Name.Lexeme, a.evaluateExpr(stmt.Start), a.evaluateExpr(stmt.Limit)))
+	if stmt.Step != nil {
+		out.WriteString(fmt.Sprintf(" step %v", a.evaluateExpr(stmt.Step)))
+	}
+	out.WriteString("\n")
+	out.WriteString(a.printBlock(stmt.Body))
+	out.WriteString("endfor")
+	return out.String()
+}
+
+func (a *AstPrinter) VisitBreakStmt(stmt *BreakStmt) interface{} {
+	return stmt.Keyword.Lexeme
+}
+
+func (a *AstPrinter) VisitContinueStmt(stmt *ContinueStmt) interface{} {
+	return stmt.Keyword.Lexeme
+}
+
+func (a *AstPrinter) VisitBlockStmt(stmt *BlockStmt) interface{} {
+	return fmt.Sprintf("do\n%send", a.printBlock(stmt.Statements))
+}
+
+func (a *AstPrinter) VisitFuncStmt(stmt *FuncStmt) interface{} {
+	params := []string{}
+	for _, param := range stmt.Params {
+		params = append(params, fmt.Sprintf("%v", param.Lexeme))
+	}
+	return fmt.Sprintf("func %v(%s)\n%send", stmt.Name.Lexeme, strings.Join(params, ", "), a.printBlock(stmt.Body))
+}
+
+func (a *AstPrinter) VisitReturnStmt(stmt *ReturnStmt) interface{} {
+	if stmt.Value == nil {
+		return "return"
+	}
+	return fmt.Sprintf("return %v", a.evaluateExpr(stmt.Value))
+}
+
+// prints a list of statements indented one level
+func (a *AstPrinter) printBlock(block []Stmt) string {
+	var out bytes.Buffer
+	for _, stmt := range block {
+		for _, line := range strings.Split(fmt.Sprintf("%v", a.executeStmt(stmt)), "\n") {
+			out.WriteString(fmt.Sprintf("  %s\n", line))
+		}
+	}
+	return out.String()
+}
+
 // Expression evaluator
 func (a *AstPrinter) evaluateExpr(e Expr) interface{} {
 	return e.Accept(a)
@@ -49,6 +120,18 @@ func (a *AstPrinter) VisitBinaryExpr(expr *Binary) interface{} {
 	return fmt.Sprintf("(%v %v %v)", a.evaluateExpr(expr.Left), expr.Operator.Lexeme, a.evaluateExpr(expr.Right))
 }
 
+func (a *AstPrinter) VisitCallExpr(expr *Call) interface{} {
+	args := []string{}
+	for _, arg := range expr.Arguments {
+		args = append(args, fmt.Sprintf("%v", a.evaluateExpr(arg)))
+	}
+	return fmt.Sprintf("%v(%s)", a.evaluateExpr(expr.Callee), strings.Join(args, ", "))
+}
+
+func (a *AstPrinter) VisitAssignExpr(expr *Assign) interface{} {
+	return fmt.Sprintf("(%v %v %v)", expr.Name.Lexeme, expr.Operator.Lexeme, a.evaluateExpr(expr.Value))
+}
+
 func (a *AstPrinter) VisitLiteralExpr(expr *Literal) interface{} {
 	if v, ok := expr.Token.Lexeme.(string); ok {
 		return fmt.Sprintf("'%s'", v)
@@ -56,6 +139,6 @@ func (a *AstPrinter) VisitLiteralExpr(expr *Literal) interface{} {
 	return expr.Token.Lexeme
 }
 
-// func (a *AstPrinter) VisitIdentifierExpr(expr *Identifier) interface{} {
-// 	return expr.Value.Lexeme
-// }
+func (a *AstPrinter) VisitIdentifierExpr(expr *Identifier) interface{} {
+	return expr.Value.Lexeme
+}
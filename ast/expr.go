@@ -6,7 +6,9 @@ type VisitorExpr interface {
 	VisitLiteralExpr(expr *Literal) interface{}
 	VisitUnaryExpr(expr *Unary) interface{}
 	VisitBinaryExpr(expr *Binary) interface{}
-	// VisitIdentifierExpr(expr *Identifier) interface{}
+	VisitCallExpr(expr *Call) interface{}
+	VisitAssignExpr(expr *Assign) interface{}
+	VisitIdentifierExpr(expr *Identifier) interface{}
 }
 
 type Expr interface {
@@ -40,10 +42,30 @@ func (expr *Binary) Accept(v VisitorExpr) interface{} {
 	return v.VisitBinaryExpr(expr)
 }
 
-// type Identifier struct {
-// 	Value token.Token
-// }
+type Call struct {
+	Callee    Expr
+	Paren     token.Token
+	Arguments []Expr
+}
+
+func (expr *Call) Accept(v VisitorExpr) interface{} {
+	return v.VisitCallExpr(expr)
+}
+
+type Assign struct {
+	Name     token.Token
+	Operator token.Token // '=' or one of the compound operators '+=', '-=', '*=', '/='
+	Value    Expr
+}
 
-// func (expr *Identifier) Accept(v VisitorExpr) interface{} {
-// 	return v.VisitIdentifierExpr(expr)
-// }
+func (expr *Assign) Accept(v VisitorExpr) interface{} {
+	return v.VisitAssignExpr(expr)
+}
+
+type Identifier struct {
+	Value token.Token
+}
+
+func (expr *Identifier) Accept(v VisitorExpr) interface{} {
+	return v.VisitIdentifierExpr(expr)
+}
@@ -6,6 +6,14 @@ type VisitorStmt interface {
 	VisitVarStmt(stmt *VarStmt) interface{}
 	VisitExprStmt(stmt *ExprStmt) interface{}
 	VisitPrintStmt(stmt *PrintStmt) interface{}
+	VisitIfStmt(stmt *IfStmt) interface{}
+	VisitWhileStmt(stmt *WhileStmt) interface{}
+	VisitForStmt(stmt *ForStmt) interface{}
+	VisitBreakStmt(stmt *BreakStmt) interface{}
+	VisitContinueStmt(stmt *ContinueStmt) interface{}
+	VisitBlockStmt(stmt *BlockStmt) interface{}
+	VisitFuncStmt(stmt *FuncStmt) interface{}
+	VisitReturnStmt(stmt *ReturnStmt) interface{}
 }
 
 type Stmt interface {
@@ -36,3 +44,79 @@ type PrintStmt struct {
 func (stmt *PrintStmt) Accept(v VisitorStmt) interface{} {
 	return v.VisitPrintStmt(stmt)
 }
+
+type IfStmt struct {
+	Keyword    token.Token
+	Condition  Expr
+	ThenBranch []Stmt
+	ElseBranch []Stmt
+}
+
+func (stmt *IfStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitIfStmt(stmt)
+}
+
+type WhileStmt struct {
+	Keyword   token.Token
+	Condition Expr
+	Body      []Stmt
+}
+
+func (stmt *WhileStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitWhileStmt(stmt)
+}
+
+type ForStmt struct {
+	Name  token.Token
+	Start Expr
+	Limit Expr
+	Step  Expr // nil when the 'step' clause is omitted
+	Body  []Stmt
+}
+
+func (stmt *ForStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitForStmt(stmt)
+}
+
+type BreakStmt struct {
+	Keyword token.Token
+}
+
+func (stmt *BreakStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitBreakStmt(stmt)
+}
+
+type ContinueStmt struct {
+	Keyword token.Token
+}
+
+func (stmt *ContinueStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitContinueStmt(stmt)
+}
+
+type BlockStmt struct {
+	Statements []Stmt
+}
+
+func (stmt *BlockStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitBlockStmt(stmt)
+}
+
+type FuncStmt struct {
+	Name   token.Token
+	Params []token.Token
+	Body   []Stmt
+}
+
+func (stmt *FuncStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitFuncStmt(stmt)
+}
+
+type ReturnStmt struct {
+	Keyword token.Token
+	Value   Expr // nil for a bare 'return'
+}
+
+func (stmt *ReturnStmt) Accept(v VisitorStmt) interface{} {
+	return v.VisitReturnStmt(stmt)
+}
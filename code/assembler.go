@@ -0,0 +1,462 @@
+package code
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"vmlite/object"
+)
+
+// Assemble translates text assembly into bytecode, the reverse of
+// Disassemble. A line holds a directive, an optional 'label:' and an
+// instruction, ';' starts a comment:
+//
+//	.name                 ; globals, in slot order
+//	  total
+//	.const                ; constants, in pool order
+//	  "hi"
+//	.func twice 1         ; a function: name and arity
+//	  LOAD_LOCAL 0
+//	  PUSHI 2
+//	  MULI
+//	  RETURN
+//	.end
+//	.code                 ; back to the main code
+//	  CLOSURE twice
+//	  PUSHI 21
+//	  CALL 1
+//	  STORE total
+//	loop:
+//	  JUMP loop
+//
+// PUSHI, PUSHF, PUSHD and PUSHS take a literal (1, 2.5, 1.25m, "text") or
+// a pool index (#0), CLOSURE a function name, STORE/LOAD a global name,
+// LOAD_BUILTIN a builtin name and jumps a label. The other operands and
+// any of the above can be given as plain numbers. A function captures
+// variables with '.upvalue local <slot>' or '.upvalue up <index>'.
+func Assemble(src string) (*Bytecode, []string) {
+	a := &assembler{
+		bc:     &Bytecode{Version: VERSION, Constants: []object.Value{}, Names: []string{}},
+		funcs:  map[string]int{},
+		errors: []string{},
+	}
+	a.main = newAsmCode(nil)
+	a.code = a.main
+	for i, line := range strings.Split(src, "\n") {
+		a.ln = i + 1
+		fields, err := splitFields(line)
+		if err != nil {
+			a.addError(err.Error())
+			continue
+		}
+		if len(fields) > 0 {
+			a.line(fields)
+		}
+	}
+	if a.code != a.main {
+		a.addError(fmt.Sprintf(".func %s has no .end", a.code.fn.Name))
+		a.endFunc()
+	}
+	a.resolveJumps(a.main)
+	a.resolveClosures()
+
+	a.bc.Instructions = a.main.ins
+	a.bc.Lines = EncodeLines(a.main.lines)
+	return a.bc, a.errors
+}
+
+type assembler struct {
+	bc       *Bytecode
+	main     *asmCode
+	code     *asmCode       // main or the function being assembled
+	section  string         // ".const", ".name" or "" for code
+	funcs    map[string]int // constant index of each function
+	closures []fixup        // CLOSURE of a function defined further down
+	errors   []string
+	ln       int
+}
+
+// the code of main or of a function while it is assembled
+type asmCode struct {
+	ins    []byte
+	lines  []Position
+	labels map[string]int
+	jumps  []fixup
+	fn     *object.CompiledFunction // nil for main
+}
+
+func newAsmCode(fn *object.CompiledFunction) *asmCode {
+	return &asmCode{ins: []byte{}, lines: []Position{}, labels: map[string]int{}, fn: fn}
+}
+
+// an operand patched once the name it refers to is known
+type fixup struct {
+	code   *asmCode
+	offset int // of the instruction
+	name   string
+	ln     int
+}
+
+func (a *assembler) line(fields []string) {
+	if strings.HasPrefix(fields[0], ".") {
+		a.directive(fields)
+		return
+	}
+	switch a.section {
+	case ".const":
+		a.constant(fields)
+		return
+	case ".name":
+		a.name(fields)
+		return
+	}
+	if label, ok := strings.CutSuffix(fields[0], ":"); ok {
+		a.label(label)
+		fields = fields[1:]
+	}
+	if len(fields) > 0 {
+		a.instruction(fields)
+	}
+}
+
+func (a *assembler) directive(fields []string) {
+	d := fields[0]
+	args := fields[1:]
+	switch d {
+	case ".const", ".name", ".code":
+		if len(args) != 0 {
+			a.addError(fmt.Sprintf("%s takes no arguments", d))
+		}
+		a.section = d
+		if d == ".code" {
+			a.section = ""
+		}
+	case ".func":
+		a.section = ""
+		if a.code != a.main {
+			a.addError(fmt.Sprintf(".func inside .func %s", a.code.fn.Name))
+			return
+		}
+		if len(args) != 2 || !isIdent(args[0]) {
+			a.addError(".func expects a name and an arity")
+			return
+		}
+		arity, err := strconv.ParseUint(args[1], 10, 8)
+		if err != nil {
+			a.addError(fmt.Sprintf("bad arity '%s' for .func %s", args[1], args[0]))
+			return
+		}
+		if _, ok := a.funcs[args[0]]; ok {
+			a.addError(fmt.Sprintf("function '%s' already defined", args[0]))
+			return
+		}
+		fn := &object.CompiledFunction{Name: args[0], Arity: int(arity)}
+		a.funcs[fn.Name] = len(a.bc.Constants)
+		a.bc.Constants = append(a.bc.Constants, object.ObjectValue(fn))
+		a.code = newAsmCode(fn)
+	case ".upvalue":
+		if a.code == a.main {
+			a.addError(".upvalue outside of a .func")
+			return
+		}
+		if len(args) != 2 || (args[0] != "local" && args[0] != "up") {
+			a.addError(".upvalue expects 'local <slot>' or 'up <index>'")
+			return
+		}
+		index, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			a.addError(fmt.Sprintf("bad upvalue index '%s'", args[1]))
+			return
+		}
+		a.code.fn.Upvalues = append(a.code.fn.Upvalues, object.UpvalueInfo{IsLocal: args[0] == "local", Index: int(index)})
+	case ".end":
+		a.section = ""
+		if a.code == a.main {
+			a.addError(".end outside of a .func")
+			return
+		}
+		a.endFunc()
+	default:
+		a.addError(fmt.Sprintf("unknown directive '%s'", d))
+	}
+}
+
+func (a *assembler) constant(fields []string) {
+	if len(fields) != 1 {
+		a.addError("a .const entry is a single literal")
+		return
+	}
+	v, err := parseLiteral(fields[0])
+	if err != nil {
+		a.addError(err.Error())
+		return
+	}
+	a.bc.Constants = append(a.bc.Constants, v)
+}
+
+func (a *assembler) name(fields []string) {
+	if len(fields) != 1 || !isIdent(fields[0]) {
+		a.addError("a .name entry is a single identifier")
+		return
+	}
+	for _, n := range a.bc.Names {
+		if n == fields[0] {
+			a.addError(fmt.Sprintf("name '%s' already declared", n))
+			return
+		}
+	}
+	a.bc.Names = append(a.bc.Names, fields[0])
+}
+
+func (a *assembler) label(label string) {
+	if !isIdent(label) {
+		a.addError(fmt.Sprintf("bad label '%s'", label))
+		return
+	}
+	if _, ok := a.code.labels[label]; ok {
+		a.addError(fmt.Sprintf("label '%s' already defined", label))
+		return
+	}
+	a.code.labels[label] = len(a.code.ins)
+}
+
+func (a *assembler) instruction(fields []string) {
+	op, ok := mnemonics[strings.ToUpper(fields[0])]
+	if !ok {
+		a.addError(fmt.Sprintf("unknown mnemonic '%s'", fields[0]))
+		return
+	}
+	def := definitions[op]
+	if len(fields)-1 != len(def.OperandWidths) {
+		a.addError(fmt.Sprintf("%s expects %d operand(s), got %d", def.Name, len(def.OperandWidths), len(fields)-1))
+		return
+	}
+
+	offset := len(a.code.ins)
+	operands := []int{}
+	for i, kind := range OperandsOf(op) {
+		operand, ok := a.operand(op, kind, fields[1+i], offset)
+		if !ok {
+			return
+		}
+		operands = append(operands, operand)
+	}
+	a.code.ins = append(a.code.ins, Make(op, operands...)...)
+	if n := len(a.code.lines); n == 0 || a.code.lines[n-1].Ln != a.ln {
+		a.code.lines = append(a.code.lines, Position{Offset: offset, Ln: a.ln, Col: 1})
+	}
+}
+
+// decodes an operand of op, names that are not known yet are fixed up
+// later with the instruction at offset
+func (a *assembler) operand(op Opcode, kind OperandKind, s string, offset int) (int, bool) {
+	name := CodeMap[op]
+	if kind == ConstOperand || kind == ValueOperand {
+		return a.constOperand(op, kind, s, offset)
+	}
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		switch kind {
+		case NameOperand:
+			if int(n) >= len(a.bc.Names) {
+				a.addError(fmt.Sprintf("%s name index %d out of range", name, n))
+				return 0, false
+			}
+		case BuiltinOperand:
+			if int(n) >= len(object.Builtins) {
+				a.addError(fmt.Sprintf("%s builtin index %d out of range", name, n))
+				return 0, false
+			}
+		case OperatorOperand:
+			if !IsBinary(Opcode(n)) {
+				a.addError(fmt.Sprintf("%s operator %d is not a binary operator", name, n))
+				return 0, false
+			}
+		}
+		return int(n), true
+	}
+
+	switch kind {
+	case NameOperand:
+		if !isIdent(s) {
+			break
+		}
+		for i, n := range a.bc.Names {
+			if n == s {
+				return i, true
+			}
+		}
+		a.bc.Names = append(a.bc.Names, s)
+		return len(a.bc.Names) - 1, true
+	case BuiltinOperand:
+		if i, ok := object.LookupBuiltin(s); ok {
+			return i, true
+		}
+		a.addError(fmt.Sprintf("unknown builtin '%s'", s))
+		return 0, false
+	case OperatorOperand:
+		if bin, ok := mnemonics[strings.ToUpper(s)]; ok && IsBinary(bin) {
+			return int(bin), true
+		}
+	case JumpOperand:
+		if !isIdent(s) {
+			break
+		}
+		a.code.jumps = append(a.code.jumps, fixup{code: a.code, offset: offset, name: s, ln: a.ln})
+		return 0, true
+	}
+	a.addError(fmt.Sprintf("bad operand '%s' for %s", s, name))
+	return 0, false
+}
+
+func (a *assembler) constOperand(op Opcode, kind OperandKind, s string, offset int) (int, bool) {
+	name := CodeMap[op]
+	if strings.HasPrefix(s, "#") {
+		i, err := strconv.ParseUint(s[1:], 10, 32)
+		if err != nil || int(i) >= len(a.bc.Constants) {
+			a.addError(fmt.Sprintf("%s constant index '%s' out of range", name, s))
+			return 0, false
+		}
+		if v := a.bc.Constants[i]; !constFits(op, kind, v) {
+			a.addError(fmt.Sprintf("bad operand '%s' for %s, got %s", s, name, v.Kind))
+			return 0, false
+		}
+		return int(i), true
+	}
+
+	if op == CLOSURE {
+		if !isIdent(s) {
+			a.addError(fmt.Sprintf("bad operand '%s' for CLOSURE, expected a function name", s))
+			return 0, false
+		}
+		if i, ok := a.funcs[s]; ok {
+			return i, true
+		}
+		a.closures = append(a.closures, fixup{code: a.code, offset: offset, name: s, ln: a.ln})
+		return 0, true
+	}
+
+	v, err := parseLiteral(s)
+	if err != nil {
+		a.addError(err.Error())
+		return 0, false
+	}
+	if !constFits(op, kind, v) {
+		a.addError(fmt.Sprintf("bad operand '%s' for %s, got %s", s, name, v.Kind))
+		return 0, false
+	}
+	key, _ := v.Key()
+	for i, c := range a.bc.Constants {
+		if k, ok := c.Key(); ok && k == key {
+			return i, true
+		}
+	}
+	a.bc.Constants = append(a.bc.Constants, v)
+	return len(a.bc.Constants) - 1, true
+}
+
+func (a *assembler) endFunc() {
+	a.resolveJumps(a.code)
+	a.code.fn.Instructions = a.code.ins
+	a.code.fn.Lines = EncodeLines(a.code.lines)
+	a.code = a.main
+}
+
+func (a *assembler) resolveJumps(c *asmCode) {
+	for _, f := range c.jumps {
+		target, ok := c.labels[f.name]
+		if !ok {
+			a.errors = append(a.errors, fmt.Sprintf("undefined label '%s' at Ln: %d.", f.name, f.ln))
+			continue
+		}
+		copy(c.ins[f.offset:], Make(c.ins[f.offset], target))
+	}
+}
+
+func (a *assembler) resolveClosures() {
+	for _, f := range a.closures {
+		i, ok := a.funcs[f.name]
+		if !ok {
+			a.errors = append(a.errors, fmt.Sprintf("undefined function '%s' at Ln: %d.", f.name, f.ln))
+			continue
+		}
+		copy(f.code.ins[f.offset:], Make(CLOSURE, i))
+	}
+}
+
+func (a *assembler) addError(msg string) {
+	a.errors = append(a.errors, fmt.Sprintf("%s at Ln: %d.", msg, a.ln))
+}
+
+// reads a constant literal: an int, a float, a decimal ending in 'm' or a
+// quoted string, the forms Disassemble prints
+func parseLiteral(s string) (object.Value, error) {
+	if strings.HasPrefix(s, "\"") {
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return object.Nil, fmt.Errorf("bad string %s", s)
+		}
+		return object.Intern(str), nil
+	}
+	if d, ok := strings.CutSuffix(s, "m"); ok {
+		v, err := object.ParseDecimal(d, object.RoundHalfUp)
+		if err != nil {
+			return object.Nil, fmt.Errorf("bad decimal '%s'", s)
+		}
+		return object.DecimalValue(v), nil
+	}
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return object.IntValue(i), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return object.FloatValue(f), nil
+	}
+	return object.Nil, fmt.Errorf("bad literal '%s'", s)
+}
+
+// splits a line into fields, quoted strings are kept whole
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == ';':
+			return fields, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i += 1
+		case c == '"':
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j += 1
+				}
+				j += 1
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;\"", rune(line[j])) {
+				j += 1
+			}
+			fields = append(fields, line[i:j])
+			i = j
+		}
+	}
+	return fields, nil
+}
+
+func isIdent(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package code_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"vmlite/code"
+	"vmlite/object"
+	"vmlite/vm"
+)
+
+// assembles and runs src, returning what it prints
+func assemble(t *testing.T, src string) (string, error) {
+	t.Helper()
+	bc, errors := code.Assemble(src)
+	if len(errors) > 0 {
+		t.Fatalf("assembler errors: %v", errors)
+	}
+	machine, err := vm.NewVMFromBytecode(bc, make([]object.Value, 8))
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	machine.SetOutput(&out)
+	err = machine.Run()
+	return out.String(), err
+}
+
+func TestAssembleRun(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"function and globals", `
+.name
+  total
+.func twice 1
+  LOAD_LOCAL 0
+  PUSHI 2
+  MULI
+  RETURN
+.end
+.code
+  CLOSURE twice
+  PUSHI 21
+  CALL 1
+  STORE total
+  LOAD total
+  PRINT`, "42\n"},
+		{"constants by index and inline", `
+.const
+  "hi"
+  2.5
+.code
+  PUSHS #0
+  PRINT
+  PUSHS "hi"
+  PUSHS " there"
+  ADDS
+  PRINT
+  PUSHF #1
+  PUSHF 0.5
+  ADDF
+  PRINT
+  PUSHD 1.25m
+  PUSHD 0.75m
+  ADDD
+  PRINT`, "hi\nhi there\n3\n2.0000\n"},
+		{"labels", `
+  PUSHI 3          ; counter
+loop:
+  LOAD_LOCAL 0
+  PUSHI 0
+  GTI
+  JUMPF done
+  LOAD_LOCAL 0
+  PRINT
+  LOAD_LOCAL 0
+  PUSHI 1
+  SUBI
+  STORE_LOCAL 0
+  JUMP loop
+done:
+  POP`, "3\n2\n1\n"},
+		{"function defined after its use", `
+  CLOSURE later
+  CALL 0
+  PRINT
+.func later 0
+  PUSHS "later"
+  RETURN
+.end`, "later\n"},
+		{"upvalues", `
+.name
+  c
+.func inc 0
+.upvalue local 0
+  LOAD_UPVALUE 0
+  PUSHI 1
+  ADDI
+  DUP
+  STORE_UPVALUE 0
+  RETURN
+.end
+.func counter 0
+  PUSHI 0
+  CLOSURE inc
+  RETURN
+.end
+.code
+  CLOSURE counter
+  CALL 0
+  STORE c
+  LOAD c
+  CALL 0
+  POP
+  LOAD c
+  CALL 0
+  PRINT`, "2\n"},
+		{"builtins", `
+  LOAD_BUILTIN len
+  PUSHS "hello"
+  CALL 1
+  PRINT`, "5\n"},
+		{"fused operator", `
+.name
+  x
+.code
+  PUSHI 4
+  STORE x
+  LOAD_CONST_OP x 3 addi
+  PRINT`, "7\n"},
+	}
+	for _, tt := range tests {
+		got, err := assemble(t, tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s printed %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"unknown mnemonic", "  FOO 1", "unknown mnemonic 'FOO' at Ln: 1."},
+		{"missing operand", "\n  PUSHI", "PUSHI expects 1 operand(s), got 0 at Ln: 2."},
+		{"constant of the wrong kind", `  PUSHI "s"`, `bad operand '"s"' for PUSHI, got string at Ln: 1.`},
+		{"constant index out of range", "  PUSHI #3", "PUSHI constant index '#3' out of range at Ln: 1."},
+		{"bad literal", "  PUSHF 1.2.3", "bad literal '1.2.3' at Ln: 1."},
+		{"bad name", "  LOAD 1x", "bad operand '1x' for LOAD at Ln: 1."},
+		{"name index out of range", "  STORE 5", "STORE name index 5 out of range at Ln: 1."},
+		{"unknown builtin", "  LOAD_BUILTIN nope", "unknown builtin 'nope' at Ln: 1."},
+		{"not an operator", ".name\n  x\n.code\n  LOAD_CONST_OP x 1 NEGI", "bad operand 'NEGI' for LOAD_CONST_OP at Ln: 4."},
+		{"operator number", "  LOAD_LOCAL_CONST_OP 0 1 11", "LOAD_LOCAL_CONST_OP operator 11 is not a binary operator at Ln: 1."},
+		{"undefined label", "  NIL\n  JUMP nowhere", "undefined label 'nowhere' at Ln: 2."},
+		{"label defined twice", "a:\na:", "label 'a' already defined at Ln: 2."},
+		{"label of another function", "top:\n.func f 0\n  JUMP top\n.end", "undefined label 'top' at Ln: 3."},
+		{"unterminated string", `  PUSHS "abc`, "unterminated string at Ln: 1."},
+		{"unknown directive", ".bogus", "unknown directive '.bogus' at Ln: 1."},
+		{"bad .const entry", ".const\n  1 2", "a .const entry is a single literal at Ln: 2."},
+		{"name declared twice", ".name\n  x\n  x", "name 'x' already declared at Ln: 3."},
+		{"undefined function", "\n\n  CLOSURE nope", "undefined function 'nope' at Ln: 3."},
+		{"closure of a number", "  CLOSURE 1x", "bad operand '1x' for CLOSURE, expected a function name at Ln: 1."},
+		{".func without arity", ".func f", ".func expects a name and an arity at Ln: 1."},
+		{"bad arity", ".func f x", "bad arity 'x' for .func f at Ln: 1."},
+		{"function defined twice", ".func f 0\n.end\n.func f 0", "function 'f' already defined at Ln: 3."},
+		{"nested .func", ".func f 0\n.func g 0\n.end", ".func inside .func f at Ln: 2."},
+		{".func without .end", ".func f 0\n  NIL\n  RETURN", ".func f has no .end at Ln: 3."},
+		{".end outside of a function", ".end", ".end outside of a .func at Ln: 1."},
+		{".upvalue outside of a function", ".upvalue local 0", ".upvalue outside of a .func at Ln: 1."},
+		{"bad .upvalue", ".func f 0\n.upvalue global 0\n.end", ".upvalue expects 'local <slot>' or 'up <index>' at Ln: 2."},
+		{"bad .upvalue index", ".func f 0\n.upvalue up x\n.end", "bad upvalue index 'x' at Ln: 2."},
+	}
+	for _, tt := range tests {
+		_, errors := code.Assemble(tt.src)
+		if len(errors) != 1 || errors[0] != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, errors, tt.want)
+		}
+	}
+}
+
+// the assembler checks the syntax, what the code does is left to the
+// verifier
+func TestAssembledCodeIsVerified(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"stack underflow", "  POP", "POP needs 1 values, the stack has 0"},
+		{"values left", "  NIL", "the code ends with 1 values on the stack"},
+		{"function without RETURN", ".func g 0\n  NIL\n  POP\n.end", "the code ends without a RETURN"},
+		{"local out of range", ".func g 0\n  LOAD_LOCAL 3\n  RETURN\n.end", "LOAD_LOCAL slot 3 out of range"},
+		{"upvalue out of range", ".func g 0\n  LOAD_UPVALUE 0\n  RETURN\n.end", "LOAD_UPVALUE upvalue 0 out of range, the function has 0"},
+		{"captured slot out of range", ".func f 0\n.upvalue local 2\n  NIL\n  RETURN\n.end\n  CLOSURE f\n  POP", "CLOSURE captures slot 2"},
+		{"jump into an instruction", "  JUMP 3", "JUMP target 0003 is not an instruction"},
+		{"unbalanced branches", "  TRUE\n  JUMPF skip\n  NIL\nskip:\n  NIL\n  POP", "reached with a stack depth of"},
+	}
+	for _, tt := range tests {
+		_, err := assemble(t, tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package code
+
+import "vmlite/object"
+
+// VERSION identifies the instruction encoding and the .vmc layout. Bump it
+// whenever an opcode, an operand or the file format changes so bytecode
+// built for another encoding is rejected.
+const VERSION = 5
+
+// Bytecode is a compiled program: the main code, its constant pool and the
+// names of its globals
+type Bytecode struct {
+	Version      uint16
+	Instructions []byte
+	Constants    []object.Value
+	Names        []string
+	Lines        []byte // source positions of Instructions
+}
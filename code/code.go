@@ -1,88 +1,397 @@
 package code
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"fmt"
+	"vmlite/object"
+)
 
 type Opcode = byte
 
 const (
-	// push float32
+	// push a float64 from co_consts
 	PUSHF Opcode = iota
+	PUSHI        // push an int64 from co_consts
+	PUSHD        // push a decimal from co_consts
 	PUSHS        // push string
 	ADDS         // add string
 	SUBS         // subtract string
-	ADD
-	SUB
-	MUL
-	DIV
-	UNEG
+	ADDI         // int64 arithmetic, overflow is a runtime error
+	SUBI
+	MULI
+	IDIVI
+	MODI
+	NEGI
+	ADDF // float64 arithmetic
+	SUBF
+	MULF
+	DIVF
+	IDIVF
+	MODF
+	NEGF
+	ADDD // decimal arithmetic, exact or rounded with the current mode
+	SUBD
+	MULD
+	DIVD
+	IDIVD
+	MODD
+	NEGD
 	NOT
 
-	UNARY
-	CMP
+	EQI // int64 comparison
+	NEQI
+	LTI
+	LEQI
+	GTI
+	GEQI
+	EQD // decimal comparison
+	NEQD
+	LTD
+	LEQD
+	GTD
+	GEQD
+	EQF // float64 comparison
+	NEQF
+	LTF
+	LEQF
+	GTF
+	GEQF
+	AND
+	OR
+
+	TRUE  // push true
+	FALSE // push false
+
+	STORE
+	LOAD
+	PRINT
+
+	JUMP  // unconditional jump to an absolute offset
+	JUMPF // pop the condition and jump if it is false
+
+	POP
+	POP_RESULT // pop the value of a top-level expression statement, the REPL shows it
+	DUP
+	LOAD_LOCAL  // push a stack slot
+	STORE_LOCAL // pop into a stack slot
+
+	NIL
+	CLOSURE // build a closure over a function from co_consts
+	CALL    // call the function below the arguments
+	RETURN  // return from the current function
+
+	LOAD_BUILTIN  // push a builtin function
+	LOAD_UPVALUE  // push a captured variable
+	STORE_UPVALUE // pop into a captured variable
+	CLOSE_UPVALUE // move the local on top of the stack into its upvalue
 
+	// generic operators, dispatched on the runtime type of the operands
+	// when the compiler cannot infer them
+	ADD
+	SUB
+	MUL
+	DIV  // always a float64 division
+	IDIV // floored division
+	MOD  // floored modulo, the result has the sign of the divisor
+	NEG
 	EQ
 	NEQ
 	LT
 	LEQ
 	GT
 	GEQ
-	AND
-	OR
 
-	BOOL
-	TRUE
-	FALSE
+	// superinstructions, fused by the peephole optimizer
+	LOAD_LOCAL2      // push two stack slots
+	LOAD_LOCAL_CONST // push a stack slot and a constant
+	LOAD_CONST       // push a global and a constant
 
-	STORE
-	LOAD
-	PRINT
+	// ... and apply a binary operator to the two values, in place of
+	// LOAD_LOCAL; PUSH*; ADDI and the like
+	LOAD_LOCAL_CONST_OP
+	LOAD_CONST_OP
 )
 
-var CodeMap = map[Opcode]string{
-	PUSHF: "PUSHF",
-	PUSHS: "PUSHS",
-	ADDS:  "ADDS",
-	SUBS:  "SUBS",
-	ADD:   "ADD",
-	SUB:   "SUB",
-	MUL:   "MUL",
-	DIV:   "DIV",
-	UNEG:  "UNEG",
-	NOT:   "NOT",
-	UNARY: "UNARY",
-	CMP:   "CMP",
-	EQ:    "EQ",
-	NEQ:   "NEQ",
-	LT:    "LT",
-	LEQ:   "LEQ",
-	GT:    "GT",
-	GEQ:   "GEQ",
-	AND:   "AND",
-	OR:    "OR",
-	BOOL:  "BOOL",
-	TRUE:  "TRUE",
-	FALSE: "FALSE",
-	STORE: "STORE",
-	LOAD:  "LOAD",
-	PRINT: "PRINT",
-}
-
-func Make(op Opcode, args ...float32) []byte {
-	// 1 = the opcode + 4 bytes for int32 * len(args)
-	// [0,  1, 2, 3, 4,   5, 6, 7, 8, 9, 10, 11, 12, ...]
-	//  ^ | ^  ^  ^  ^  | ^  ^  ^  ^ |
-	//  |       |			   |
-	//  |       |			   |
-	//  v       v			   v
-	// opCode arg1			  arg2
-	size := 1 + 4*len(args) // eg: 10 1 2 => 1 + 4 * 2 = 9
-	b := make([]byte, size)
-	b[0] = op
-	for i, v := range args {
-		// offset formula => i * 4 + 1
-		o := i*4 + 1
-		binary.BigEndian.PutUint32(b[o:], uint32(v))
+// Definition describes the operands that follow an opcode, each operand is
+// stored big endian in the given number of bytes
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	PUSHF: {"PUSHF", []int{4}},
+	PUSHI: {"PUSHI", []int{4}},
+	PUSHD: {"PUSHD", []int{4}},
+	PUSHS: {"PUSHS", []int{4}},
+	ADDS:  {"ADDS", []int{}},
+	SUBS:  {"SUBS", []int{}},
+	ADDI:  {"ADDI", []int{}},
+	SUBI:  {"SUBI", []int{}},
+	MULI:  {"MULI", []int{}},
+	IDIVI: {"IDIVI", []int{}},
+	MODI:  {"MODI", []int{}},
+	NEGI:  {"NEGI", []int{}},
+	ADDF:  {"ADDF", []int{}},
+	SUBF:  {"SUBF", []int{}},
+	MULF:  {"MULF", []int{}},
+	DIVF:  {"DIVF", []int{}},
+	IDIVF: {"IDIVF", []int{}},
+	MODF:  {"MODF", []int{}},
+	NEGF:  {"NEGF", []int{}},
+	ADDD:  {"ADDD", []int{}},
+	SUBD:  {"SUBD", []int{}},
+	MULD:  {"MULD", []int{}},
+	DIVD:  {"DIVD", []int{}},
+	IDIVD: {"IDIVD", []int{}},
+	MODD:  {"MODD", []int{}},
+	NEGD:  {"NEGD", []int{}},
+	NOT:   {"NOT", []int{}},
+	EQI:   {"EQI", []int{}},
+	NEQI:  {"NEQI", []int{}},
+	LTI:   {"LTI", []int{}},
+	LEQI:  {"LEQI", []int{}},
+	GTI:   {"GTI", []int{}},
+	GEQI:  {"GEQI", []int{}},
+	EQD:   {"EQD", []int{}},
+	NEQD:  {"NEQD", []int{}},
+	LTD:   {"LTD", []int{}},
+	LEQD:  {"LEQD", []int{}},
+	GTD:   {"GTD", []int{}},
+	GEQD:  {"GEQD", []int{}},
+	EQF:   {"EQF", []int{}},
+	NEQF:  {"NEQF", []int{}},
+	LTF:   {"LTF", []int{}},
+	LEQF:  {"LEQF", []int{}},
+	GTF:   {"GTF", []int{}},
+	GEQF:  {"GEQF", []int{}},
+	AND:   {"AND", []int{}},
+	OR:    {"OR", []int{}},
+	TRUE:  {"TRUE", []int{}},
+	FALSE: {"FALSE", []int{}},
+	STORE: {"STORE", []int{4}},
+	LOAD:  {"LOAD", []int{4}},
+	PRINT: {"PRINT", []int{}},
+	JUMP:  {"JUMP", []int{4}},
+	JUMPF: {"JUMPF", []int{4}},
+	POP:   {"POP", []int{}},
+	DUP:   {"DUP", []int{}},
+
+	POP_RESULT: {"POP_RESULT", []int{}},
+
+	LOAD_LOCAL:  {"LOAD_LOCAL", []int{4}},
+	STORE_LOCAL: {"STORE_LOCAL", []int{4}},
+
+	NIL:     {"NIL", []int{}},
+	CLOSURE: {"CLOSURE", []int{4}},
+	CALL:    {"CALL", []int{4}},
+	RETURN:  {"RETURN", []int{}},
+
+	LOAD_BUILTIN:  {"LOAD_BUILTIN", []int{4}},
+	LOAD_UPVALUE:  {"LOAD_UPVALUE", []int{4}},
+	STORE_UPVALUE: {"STORE_UPVALUE", []int{4}},
+	CLOSE_UPVALUE: {"CLOSE_UPVALUE", []int{}},
+
+	ADD:  {"ADD", []int{}},
+	SUB:  {"SUB", []int{}},
+	MUL:  {"MUL", []int{}},
+	DIV:  {"DIV", []int{}},
+	IDIV: {"IDIV", []int{}},
+	MOD:  {"MOD", []int{}},
+	NEG:  {"NEG", []int{}},
+	EQ:   {"EQ", []int{}},
+	NEQ:  {"NEQ", []int{}},
+	LT:   {"LT", []int{}},
+	LEQ:  {"LEQ", []int{}},
+	GT:   {"GT", []int{}},
+	GEQ:  {"GEQ", []int{}},
+
+	LOAD_LOCAL2:      {"LOAD_LOCAL2", []int{4, 4}},
+	LOAD_LOCAL_CONST: {"LOAD_LOCAL_CONST", []int{4, 4}},
+	LOAD_CONST:       {"LOAD_CONST", []int{4, 4}},
+
+	LOAD_LOCAL_CONST_OP: {"LOAD_LOCAL_CONST_OP", []int{4, 4, 1}},
+	LOAD_CONST_OP:       {"LOAD_CONST_OP", []int{4, 4, 1}},
+}
+
+// OperandKind tells what an operand of an instruction refers to
+type OperandKind byte
+
+const (
+	NoOperand       OperandKind = iota
+	ConstOperand                // index into the constant pool
+	ValueOperand                // ... of a number or a string
+	NameOperand                 // index into the global names
+	JumpOperand                 // absolute offset in the same code
+	LocalOperand                // stack slot from the frame base
+	UpvalueOperand              // index into the closure upvalues
+	BuiltinOperand              // index into object.Builtins
+	ArgcOperand                 // number of call arguments
+	OperatorOperand             // a binary operator opcode
+)
+
+var operandKinds = map[Opcode][]OperandKind{
+	PUSHF:            {ConstOperand},
+	PUSHI:            {ConstOperand},
+	PUSHD:            {ConstOperand},
+	PUSHS:            {ConstOperand},
+	CLOSURE:          {ConstOperand},
+	STORE:            {NameOperand},
+	LOAD:             {NameOperand},
+	JUMP:             {JumpOperand},
+	JUMPF:            {JumpOperand},
+	LOAD_LOCAL:       {LocalOperand},
+	STORE_LOCAL:      {LocalOperand},
+	LOAD_UPVALUE:     {UpvalueOperand},
+	STORE_UPVALUE:    {UpvalueOperand},
+	LOAD_BUILTIN:     {BuiltinOperand},
+	CALL:             {ArgcOperand},
+	LOAD_LOCAL2:      {LocalOperand, LocalOperand},
+	LOAD_LOCAL_CONST: {LocalOperand, ValueOperand},
+	LOAD_CONST:       {NameOperand, ValueOperand},
+
+	LOAD_LOCAL_CONST_OP: {LocalOperand, ValueOperand, OperatorOperand},
+	LOAD_CONST_OP:       {NameOperand, ValueOperand, OperatorOperand},
+}
+
+// OperandsOf returns what each operand of op refers to
+func OperandsOf(op Opcode) []OperandKind {
+	return operandKinds[op]
+}
+
+// the operators that pop two values and push one
+var binaryOps = map[Opcode]bool{
+	ADDS: true, SUBS: true,
+	ADDI: true, SUBI: true, MULI: true, IDIVI: true, MODI: true,
+	ADDF: true, SUBF: true, MULF: true, DIVF: true, IDIVF: true, MODF: true,
+	ADDD: true, SUBD: true, MULD: true, DIVD: true, IDIVD: true, MODD: true,
+	EQI: true, NEQI: true, LTI: true, LEQI: true, GTI: true, GEQI: true,
+	EQD: true, NEQD: true, LTD: true, LEQD: true, GTD: true, GEQD: true,
+	EQF: true, NEQF: true, LTF: true, LEQF: true, GTF: true, GEQF: true,
+	AND: true, OR: true,
+	ADD: true, SUB: true, MUL: true, DIV: true, IDIV: true, MOD: true,
+	EQ: true, NEQ: true, LT: true, LEQ: true, GT: true, GEQ: true,
+}
+
+// IsBinary tells if op pops two values and pushes the result
+func IsBinary(op Opcode) bool {
+	return binaryOps[op]
+}
+
+// the kind of constant each opcode pushes
+var constKinds = map[Opcode]object.ValueKind{
+	PUSHI:   object.IntKind,
+	PUSHF:   object.FloatKind,
+	PUSHD:   object.DecimalKind,
+	PUSHS:   object.StringKind,
+	CLOSURE: object.ObjectKind,
+}
+
+// tells if constant v can be the operand of op
+func constFits(op Opcode, kind OperandKind, v object.Value) bool {
+	if kind == ValueOperand {
+		return v.IsNumber() || v.Kind == object.StringKind
 	}
+	if op == CLOSURE {
+		_, ok := v.AsObject().(*object.CompiledFunction)
+		return ok && v.Kind == object.ObjectKind
+	}
+	return v.Kind == constKinds[op]
+}
+
+// opcode names, used by error messages
+var CodeMap = map[Opcode]string{}
+
+// opcodes by name, used by the assembler
+var mnemonics = map[string]Opcode{}
+
+func init() {
+	for op, def := range definitions {
+		CodeMap[op] = def.Name
+		mnemonics[def.Name] = op
+	}
+}
+
+func Lookup(op Opcode) (*Definition, error) {
+	def, ok := definitions[op]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
 
+// Make encodes an instruction, the operands must fit their declared width
+//
+//	PUSHF 1        TRUE
+//	[op 0 0 0 1]   [op]
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	b := make([]byte, InstructionSize(op))
+	b[0] = op
+	o := 1
+	for i, v := range operands {
+		w := def.OperandWidths[i]
+		switch w {
+		case 1:
+			b[o] = byte(v)
+		case 2:
+			binary.BigEndian.PutUint16(b[o:], uint16(v))
+		case 4:
+			binary.BigEndian.PutUint32(b[o:], uint32(v))
+		}
+		o += w
+	}
 	return b
 }
+
+// InstructionSize is the number of bytes of an instruction, opcode included
+func InstructionSize(op Opcode) int {
+	size := 1
+	if def, ok := definitions[op]; ok {
+		for _, w := range def.OperandWidths {
+			size += w
+		}
+	}
+	return size
+}
+
+// ReadOperands decodes the operands of an instruction, ins starts right
+// after the opcode. It also returns the number of bytes read.
+func ReadOperands(def *Definition, ins []byte) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	o := 0
+	for i, w := range def.OperandWidths {
+		operands[i] = ReadOperand(w, ins[o:])
+		o += w
+	}
+	return operands, o
+}
+
+// ReadOperand decodes one operand of the given width
+func ReadOperand(width int, ins []byte) int {
+	switch width {
+	case 1:
+		return int(ReadUint8(ins))
+	case 2:
+		return int(ReadUint16(ins))
+	case 4:
+		return int(ReadUint32(ins))
+	}
+	return 0
+}
+
+func ReadUint8(ins []byte) uint8 {
+	return ins[0]
+}
+
+func ReadUint16(ins []byte) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint32(ins []byte) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
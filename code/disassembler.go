@@ -0,0 +1,206 @@
+package code
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"vmlite/object"
+)
+
+// Disassemble lists the main code of bc followed by the functions it
+// builds, nested functions included. Each instruction shows its offset,
+// name and operands; constants, globals, builtins and jump targets are
+// annotated and the source position is shown when it changes. The
+// statistics of the constant pool come last.
+//
+//	== main ==
+//	    1:6  0000  CLOSURE             1  <func f>
+//	         0005  STORE               0  f
+func Disassemble(bc *Bytecode) string {
+	var out strings.Builder
+	d := &disassembler{out: &out, constants: bc.Constants, names: bc.Names, seen: map[*object.CompiledFunction]bool{}}
+	d.function("main", bc.Instructions, bc.Lines)
+	for len(d.pending) > 0 {
+		fn := d.pending[0]
+		d.pending = d.pending[1:]
+		out.WriteString("\n")
+		d.function(fn.Name, fn.Instructions, fn.Lines)
+	}
+	out.WriteString("\n== pool ==\n")
+	out.WriteString(Stats(bc).String())
+	return out.String()
+}
+
+// PoolStats summarizes the constant pool and the names of a program
+type PoolStats struct {
+	Constants   int
+	Kinds       map[object.ValueKind]int // constants of each kind
+	Duplicates  int                      // constants with the kind and value of an earlier one
+	Interned    int                      // strings shared through object.Intern
+	StringBytes int
+	Names       int
+}
+
+func Stats(bc *Bytecode) PoolStats {
+	s := PoolStats{Constants: len(bc.Constants), Kinds: map[object.ValueKind]int{}, Names: len(bc.Names)}
+	seen := map[object.ConstKey]bool{}
+	for _, c := range bc.Constants {
+		s.Kinds[c.Kind] += 1
+		if key, ok := c.Key(); ok {
+			if seen[key] {
+				s.Duplicates += 1
+			}
+			seen[key] = true
+		}
+		if c.Kind == object.StringKind {
+			s.StringBytes += len(c.AsString())
+			if c.IsInterned() {
+				s.Interned += 1
+			}
+		}
+	}
+	return s
+}
+
+// String lists the stats one per line:
+//
+//	constants   5: 2 int, 1 string, 2 function
+//	duplicates  0
+//	strings     1 interned, 3 bytes
+//	names       2
+func (s PoolStats) String() string {
+	kinds := []string{}
+	for k := object.NilKind; k <= object.ObjectKind; k++ {
+		if n := s.Kinds[k]; n > 0 {
+			kinds = append(kinds, fmt.Sprintf("%d %s", n, k))
+		}
+	}
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("constants  %2d", s.Constants))
+	if len(kinds) > 0 {
+		out.WriteString(": " + strings.Join(kinds, ", "))
+	}
+	out.WriteString(fmt.Sprintf("\nduplicates %2d\n", s.Duplicates))
+	out.WriteString(fmt.Sprintf("strings    %2d interned, %d bytes\n", s.Interned, s.StringBytes))
+	out.WriteString(fmt.Sprintf("names      %2d\n", s.Names))
+	return out.String()
+}
+
+// DisassembleCode lists a single piece of code without a header and
+// without the functions it builds
+func DisassembleCode(ins []byte, lines []byte, constants []object.Value, names []string) string {
+	var out strings.Builder
+	d := &disassembler{out: &out, constants: constants, names: names, seen: map[*object.CompiledFunction]bool{}}
+	d.instructions(ins, lines)
+	return out.String()
+}
+
+type disassembler struct {
+	out       *strings.Builder
+	constants []object.Value
+	names     []string
+	pending   []*object.CompiledFunction // functions still to be listed
+	seen      map[*object.CompiledFunction]bool
+}
+
+func (d *disassembler) function(name string, ins []byte, lines []byte) {
+	d.out.WriteString(fmt.Sprintf("== %s ==\n", name))
+	d.instructions(ins, lines)
+}
+
+func (d *disassembler) instructions(ins []byte, lines []byte) {
+	positions := DecodeLines(lines)
+	next := 0 // next position to show
+	ip := 0
+	for ip < len(ins) {
+		pos := ""
+		for next < len(positions) && positions[next].Offset <= ip {
+			pos = fmt.Sprintf("%d:%d", positions[next].Ln, positions[next].Col)
+			next += 1
+		}
+		d.out.WriteString(fmt.Sprintf("%7s  %04d  ", pos, ip))
+
+		op := ins[ip]
+		def, err := Lookup(op)
+		if err != nil {
+			d.out.WriteString(fmt.Sprintf("ERROR: %s\n", err))
+			ip += 1
+			continue
+		}
+		size := InstructionSize(op)
+		if ip+size > len(ins) {
+			d.out.WriteString(fmt.Sprintf("ERROR: truncated %s instruction\n", def.Name))
+			return
+		}
+		operands, _ := ReadOperands(def, ins[ip+1:])
+		if len(operands) == 0 {
+			d.out.WriteString(def.Name + "\n")
+			ip += size
+			continue
+		}
+		d.out.WriteString(fmt.Sprintf("%-20s", def.Name))
+		notes := []string{}
+		for i, o := range operands {
+			d.out.WriteString(fmt.Sprintf(" %4d", o))
+			if note := d.annotate(OperandsOf(op)[i], o); note != "" {
+				notes = append(notes, note)
+			}
+		}
+		if len(notes) > 0 {
+			d.out.WriteString("  " + strings.Join(notes, ", "))
+		}
+		d.out.WriteString("\n")
+		ip += size
+	}
+}
+
+// describes what an operand refers to
+func (d *disassembler) annotate(kind OperandKind, operand int) string {
+	switch kind {
+	case ConstOperand, ValueOperand:
+		if operand >= len(d.constants) {
+			return "<bad constant>"
+		}
+		v := d.constants[operand]
+		if fn, ok := v.AsObject().(*object.CompiledFunction); ok && v.Kind == object.ObjectKind && !d.seen[fn] {
+			d.seen[fn] = true
+			d.pending = append(d.pending, fn)
+		}
+		return formatConstant(v)
+	case NameOperand:
+		if operand >= len(d.names) {
+			return "<bad name>"
+		}
+		return d.names[operand]
+	case BuiltinOperand:
+		if operand >= len(object.Builtins) {
+			return "<bad builtin>"
+		}
+		return object.Builtins[operand].Name
+	case JumpOperand:
+		return fmt.Sprintf("-> %04d", operand)
+	case OperatorOperand:
+		if !IsBinary(Opcode(operand)) {
+			return "<bad operator>"
+		}
+		return CodeMap[Opcode(operand)]
+	}
+	return ""
+}
+
+// constants are shown with their type so 1, 1.0 and "1" differ
+func formatConstant(v object.Value) string {
+	switch v.Kind {
+	case object.StringKind:
+		return strconv.Quote(v.AsString())
+	case object.DecimalKind:
+		return v.String() + "m"
+	case object.FloatKind:
+		s := v.String()
+		if !strings.ContainsAny(s, ".eEnN") { // NaN and Inf have no dot
+			s += ".0"
+		}
+		return s
+	}
+	return v.String()
+}
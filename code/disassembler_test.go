@@ -0,0 +1,73 @@
+package code
+
+import (
+	"bytes"
+	"testing"
+	"vmlite/object"
+)
+
+func TestDisassemble(t *testing.T) {
+	bc := sample()
+	bc.Instructions = bytes.Join([][]byte{
+		bc.Instructions,
+		Make(LOAD_BUILTIN, 0),
+		Make(PUSHS, 4),
+		Make(CALL, 1),
+		Make(POP),
+		Make(LOAD, 1),
+		Make(JUMPF, 0),
+		Make(LOAD_CONST_OP, 1, 5, int(SUB)),
+		Make(STORE, 1),
+	}, nil)
+	bc.Constants = append(bc.Constants, object.IntValue(-42), object.Intern("héllo"))
+
+	want := `== main ==
+    1:6  0000  CLOSURE                 0  <func f>
+         0005  STORE                   0  f
+    5:7  0010  PUSHS                   4  "héllo"
+         0015  PRINT
+         0016  LOAD_BUILTIN            0  len
+         0021  PUSHS                   4  "héllo"
+         0026  CALL                    1
+         0031  POP
+         0032  LOAD                    1  x
+         0037  JUMPF                   0  -> 0000
+         0042  LOAD_CONST_OP           1    5   68  x, -42, SUB
+         0052  STORE                   1  x
+
+== f ==
+    2:3  0000  LOAD_LOCAL              0
+         0005  RETURN
+
+== pool ==
+constants   7: 2 int, 1 decimal, 1 float, 2 string, 1 function
+duplicates  2
+strings     1 interned, 12 bytes
+names       2
+`
+	if got := Disassemble(bc); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		constants []object.Value
+		names     []string
+		want      string
+	}{
+		{"empty", nil, nil, "constants   0\nduplicates  0\nstrings     0 interned, 0 bytes\nnames       0\n"},
+		{"duplicates", []object.Value{
+			object.IntValue(1), object.FloatValue(1), object.IntValue(1),
+			object.StringValue("ab"), object.Intern("ab"), object.DecimalValue(10000),
+		}, []string{"a", "b", "c"},
+			"constants   6: 2 int, 1 decimal, 1 float, 2 string\nduplicates  2\nstrings     1 interned, 4 bytes\nnames       3\n"},
+	}
+	for _, tt := range tests {
+		got := Stats(&Bytecode{Constants: tt.constants, Names: tt.names}).String()
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"vmlite/object"
+)
+
+// A .vmc file holds a Bytecode so a program is compiled once and run many
+// times. Every number is big endian, like the instruction operands:
+//
+//	magic    "VMLC"
+//	version  uint16, must be VERSION
+//	length   uint32, size of the body
+//	body     constants, names, code, line table
+//	checksum uint32, CRC-32 (IEEE) of the body
+//
+// The constants are typed entries (a kind byte and its payload), compiled
+// functions are stored with their own code and line table. Strings and
+// byte sections are a uint32 length followed by the bytes.
+const MAGIC = "VMLC"
+
+const headerSize = len(MAGIC) + 2 + 4
+
+var ErrNotBytecode = fmt.Errorf("not a vmlite bytecode file")
+var ErrTruncated = fmt.Errorf("truncated bytecode file")
+var ErrChecksum = fmt.Errorf("bytecode file checksum mismatch")
+
+// Save writes bc in the .vmc format
+func (bc *Bytecode) Save(w io.Writer) error {
+	body := &encoder{}
+	body.uint32(len(bc.Constants))
+	for i, c := range bc.Constants {
+		if err := body.constant(c); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+	body.uint32(len(bc.Names))
+	for _, n := range bc.Names {
+		body.bytes([]byte(n))
+	}
+	body.bytes(bc.Instructions)
+	body.bytes(bc.Lines)
+
+	out := &encoder{}
+	out.buf.WriteString(MAGIC)
+	out.uint16(int(bc.Version))
+	out.uint32(body.buf.Len())
+	out.buf.Write(body.buf.Bytes())
+	out.uint32(int(crc32.ChecksumIEEE(body.buf.Bytes())))
+	_, err := w.Write(out.buf.Bytes())
+	return err
+}
+
+// Load reads a .vmc file, files of another version, truncated or
+// corrupted ones are rejected
+func Load(r io.Reader) (*Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(MAGIC) || string(data[:len(MAGIC)]) != MAGIC {
+		return nil, ErrNotBytecode
+	}
+	if len(data) < headerSize {
+		return nil, ErrTruncated
+	}
+	version := ReadUint16(data[len(MAGIC):])
+	if version != VERSION {
+		return nil, fmt.Errorf("bytecode version %d is not supported, expected version %d", version, VERSION)
+	}
+	size := int(ReadUint32(data[len(MAGIC)+2:]))
+	if len(data)-headerSize < size+4 {
+		return nil, ErrTruncated
+	}
+	if extra := len(data) - headerSize - size - 4; extra > 0 {
+		return nil, fmt.Errorf("%d unexpected bytes after the checksum", extra)
+	}
+	body := data[headerSize : headerSize+size]
+	if ReadUint32(data[headerSize+size:]) != crc32.ChecksumIEEE(body) {
+		return nil, ErrChecksum
+	}
+
+	d := &decoder{data: body}
+	bc := &Bytecode{Version: version}
+	n := d.uint32()
+	bc.Constants = make([]object.Value, 0, d.capacity(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		bc.Constants = append(bc.Constants, d.constant())
+	}
+	n = d.uint32()
+	bc.Names = make([]string, 0, d.capacity(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		bc.Names = append(bc.Names, string(d.bytes()))
+	}
+	bc.Instructions = d.bytes()
+	bc.Lines = d.bytes()
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d unexpected bytes after the line table", len(d.data))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return bc, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint16(v int) {
+	e.buf.Write(binary.BigEndian.AppendUint16(nil, uint16(v)))
+}
+
+func (e *encoder) uint32(v int) {
+	e.buf.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint32(len(b))
+	e.buf.Write(b)
+}
+
+func (e *encoder) constant(v object.Value) error {
+	e.buf.WriteByte(byte(v.Kind))
+	switch v.Kind {
+	case object.IntKind:
+		e.uint64(uint64(v.AsInt()))
+	case object.DecimalKind:
+		e.uint64(uint64(v.AsDecimal()))
+	case object.FloatKind:
+		e.uint64(math.Float64bits(v.AsFloat()))
+	case object.StringKind:
+		e.bytes([]byte(v.AsString()))
+	case object.ObjectKind:
+		fn, ok := v.AsObject().(*object.CompiledFunction)
+		if !ok {
+			return fmt.Errorf("cannot save %v", v)
+		}
+		e.bytes([]byte(fn.Name))
+		e.uint32(fn.Arity)
+		e.uint32(len(fn.Upvalues))
+		for _, u := range fn.Upvalues {
+			if u.IsLocal {
+				e.buf.WriteByte(1)
+			} else {
+				e.buf.WriteByte(0)
+			}
+			e.uint32(u.Index)
+		}
+		e.bytes(fn.Instructions)
+		e.bytes(fn.Lines)
+	default:
+		return fmt.Errorf("cannot save a %s", v.Kind)
+	}
+	return nil
+}
+
+// reads the body, the first error sticks and every later read is empty
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = ErrTruncated
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() int {
+	if b := d.next(4); b != nil {
+		return int(ReadUint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) bytes() []byte {
+	b := d.next(d.uint32())
+	return append([]byte{}, b...)
+}
+
+// a count read from the file cannot preallocate more than what is left
+func (d *decoder) capacity(n int) int {
+	if n > len(d.data) {
+		return len(d.data)
+	}
+	return n
+}
+
+func (d *decoder) constant() object.Value {
+	switch kind := object.ValueKind(d.byte()); kind {
+	case object.IntKind:
+		return object.IntValue(int64(d.uint64()))
+	case object.DecimalKind:
+		return object.DecimalValue(object.Decimal(d.uint64()))
+	case object.FloatKind:
+		return object.FloatValue(math.Float64frombits(d.uint64()))
+	case object.StringKind:
+		return object.Intern(string(d.bytes()))
+	case object.ObjectKind:
+		fn := &object.CompiledFunction{Name: string(d.bytes()), Arity: d.uint32()}
+		n := d.uint32()
+		fn.Upvalues = make([]object.UpvalueInfo, 0, d.capacity(n))
+		for i := 0; i < n && d.err == nil; i++ {
+			isLocal := d.byte() == 1
+			fn.Upvalues = append(fn.Upvalues, object.UpvalueInfo{IsLocal: isLocal, Index: d.uint32()})
+		}
+		fn.Instructions = d.bytes()
+		fn.Lines = d.bytes()
+		return object.ObjectValue(fn)
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown constant kind %d", kind)
+		}
+	}
+	return object.Nil
+}
@@ -0,0 +1,120 @@
+package code
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"vmlite/object"
+)
+
+// a program with every kind of constant
+func sample() *Bytecode {
+	fn := &object.CompiledFunction{
+		Name:         "f",
+		Arity:        2,
+		Instructions: append(Make(LOAD_LOCAL, 0), Make(RETURN)...),
+		Upvalues:     []object.UpvalueInfo{{IsLocal: true, Index: 1}, {IsLocal: false, Index: 0}},
+		Lines:        EncodeLines([]Position{{Offset: 0, Ln: 2, Col: 3}}),
+	}
+	return &Bytecode{
+		Version: VERSION,
+		Instructions: bytes.Join([][]byte{
+			Make(CLOSURE, 0),
+			Make(STORE, 0),
+			Make(PUSHS, 4),
+			Make(PRINT),
+		}, nil),
+		Constants: []object.Value{
+			object.ObjectValue(fn),
+			object.IntValue(-42),
+			object.DecimalValue(123456),
+			object.FloatValue(-0.0),
+			object.StringValue("héllo"),
+		},
+		Names: []string{"f", "x"},
+		Lines: EncodeLines([]Position{{Offset: 0, Ln: 1, Col: 6}, {Offset: 10, Ln: 5, Col: 7}}),
+	}
+}
+
+func save(t *testing.T, bc *Bytecode) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := bc.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	want := sample()
+	got, err := Load(bytes.NewReader(save(t, want)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got.Version != want.Version || !bytes.Equal(got.Instructions, want.Instructions) ||
+		!bytes.Equal(got.Lines, want.Lines) || !reflect.DeepEqual(got.Names, want.Names) {
+		t.Fatalf("Load = %+v, want %+v", got, want)
+	}
+	if len(got.Constants) != len(want.Constants) {
+		t.Fatalf("%d constants, want %d", len(got.Constants), len(want.Constants))
+	}
+	for i, c := range want.Constants {
+		g := got.Constants[i]
+		if fn, ok := c.AsObject().(*object.CompiledFunction); ok {
+			if !reflect.DeepEqual(g.AsObject(), fn) {
+				t.Errorf("constant %d = %+v, want %+v", i, g.AsObject(), fn)
+			}
+			continue
+		}
+		if g.Kind == object.StringKind && !g.IsInterned() {
+			t.Errorf("constant %d: loaded strings must be interned", i)
+		}
+		gk, _ := g.Key()
+		wk, _ := c.Key()
+		if gk != wk {
+			t.Errorf("constant %d = %v (%s), want %v (%s)", i, g, g.Kind, c, c.Kind)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	good := save(t, sample())
+	body := len(good) - headerSize - 4
+	edit := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, good...))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error  // a sentinel error
+		msg  string // or part of the message
+	}{
+		{"empty", []byte{}, ErrNotBytecode, ""},
+		{"bad magic", edit(func(b []byte) []byte { b[0] = 'X'; return b }), ErrNotBytecode, ""},
+		{"not a bytecode file", []byte("print 1 + 2"), ErrNotBytecode, ""},
+		{"truncated header", good[:headerSize-1], ErrTruncated, ""},
+		{"truncated body", good[:headerSize+body/2], ErrTruncated, ""},
+		{"missing checksum", good[:len(good)-2], ErrTruncated, ""},
+		{"older version", edit(func(b []byte) []byte { b[len(MAGIC)+1] = VERSION - 1; return b }), nil, "version 4 is not supported"},
+		{"newer version", edit(func(b []byte) []byte { b[len(MAGIC)] = 1; return b }), nil, "is not supported"},
+		{"corrupted body", edit(func(b []byte) []byte { b[headerSize+body/2] ^= 0xff; return b }), ErrChecksum, ""},
+		{"corrupted checksum", edit(func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), ErrChecksum, ""},
+		{"bytes after the checksum", append(append([]byte{}, good...), 0), nil, "1 unexpected bytes after the checksum"},
+	}
+	for _, tt := range tests {
+		bc, err := Load(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: Load = %+v, want an error", tt.name, bc)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if tt.msg != "" && !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.msg)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package code
+
+import "encoding/binary"
+
+// Position is the source position of the instructions from Offset on,
+// until the next position of the table
+type Position struct {
+	Offset int
+	Ln     int
+	Col    int
+}
+
+// EncodeLines packs positions sorted by offset into a compact line table.
+// Each entry is three varints: the offset delta, the line delta and the
+// column, so most entries take 3 bytes.
+func EncodeLines(positions []Position) []byte {
+	lines := []byte{}
+	offset, ln := 0, 0
+	for _, p := range positions {
+		lines = binary.AppendUvarint(lines, uint64(p.Offset-offset))
+		lines = binary.AppendVarint(lines, int64(p.Ln-ln))
+		lines = binary.AppendUvarint(lines, uint64(p.Col))
+		offset, ln = p.Offset, p.Ln
+	}
+	return lines
+}
+
+// DecodeLines unpacks a line table, a malformed table is read up to the
+// first bad entry
+func DecodeLines(lines []byte) []Position {
+	positions := []Position{}
+	r := lineReader{lines: lines}
+	for r.next() {
+		positions = append(positions, r.pos)
+	}
+	return positions
+}
+
+// LookupLine returns the source position of the instruction at ip, 0, 0
+// when the table has none
+func LookupLine(lines []byte, ip int) (int, int) {
+	ln, col := 0, 0
+	r := lineReader{lines: lines}
+	for r.next() && r.pos.Offset <= ip {
+		ln, col = r.pos.Ln, r.pos.Col
+	}
+	return ln, col
+}
+
+// walks a line table entry by entry without allocating
+type lineReader struct {
+	lines []byte
+	pos   Position
+}
+
+func (r *lineReader) next() bool {
+	offset, n1 := binary.Uvarint(r.lines)
+	if n1 <= 0 {
+		return false
+	}
+	ln, n2 := binary.Varint(r.lines[n1:])
+	if n2 <= 0 {
+		return false
+	}
+	col, n3 := binary.Uvarint(r.lines[n1+n2:])
+	if n3 <= 0 {
+		return false
+	}
+	r.lines = r.lines[n1+n2+n3:]
+	r.pos.Offset += int(offset)
+	r.pos.Ln += int(ln)
+	r.pos.Col = int(col)
+	return true
+}
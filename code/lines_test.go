@@ -0,0 +1,68 @@
+package code
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		positions []Position
+	}{
+		{"empty", []Position{}},
+		{"one", []Position{{Offset: 0, Ln: 1, Col: 1}}},
+		{"same line", []Position{{0, 3, 1}, {2, 3, 9}, {5, 3, 14}}},
+		// a loop condition is compiled after its body
+		{"line going back", []Position{{0, 10, 5}, {4, 12, 3}, {9, 10, 11}, {12, 1, 1}}},
+		{"column going back", []Position{{0, 4, 40}, {3, 4, 2}, {6, 4, 200}, {7, 4, 1}}},
+		{"large deltas", []Position{{0, 1, 1}, {70000, 1_000_000, 5000}, {70001, 2, 300}, {1 << 30, 1 << 20, 1 << 16}}},
+	}
+	for _, tt := range tests {
+		lines := EncodeLines(tt.positions)
+		if got := DecodeLines(lines); !reflect.DeepEqual(got, tt.positions) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.positions)
+		}
+	}
+}
+
+func TestLinesSize(t *testing.T) {
+	lines := EncodeLines([]Position{{0, 1, 1}, {3, 2, 5}, {9, 1, 100}})
+	if len(lines) != 9 {
+		t.Errorf("got %d bytes, want 9", len(lines))
+	}
+}
+
+func TestLookupLine(t *testing.T) {
+	lines := EncodeLines([]Position{{0, 2, 1}, {4, 3, 7}, {10, 1, 2}})
+	tests := []struct {
+		ip      int
+		ln, col int
+	}{
+		{0, 2, 1},
+		{3, 2, 1},
+		{4, 3, 7},
+		{9, 3, 7},
+		{10, 1, 2},
+		{500, 1, 2},
+	}
+	for _, tt := range tests {
+		if ln, col := LookupLine(lines, tt.ip); ln != tt.ln || col != tt.col {
+			t.Errorf("ip %d: got %d, %d, want %d, %d", tt.ip, ln, col, tt.ln, tt.col)
+		}
+	}
+	if ln, col := LookupLine(nil, 0); ln != 0 || col != 0 {
+		t.Errorf("empty table: got %d, %d, want 0, 0", ln, col)
+	}
+}
+
+// a truncated table is read up to its last whole entry
+func TestDecodeTruncatedLines(t *testing.T) {
+	positions := []Position{{0, 1, 1}, {3, 200, 300}}
+	lines := EncodeLines(positions)
+	for n := len(lines) - 1; n > 3; n-- {
+		if got := DecodeLines(lines[:n]); !reflect.DeepEqual(got, positions[:1]) {
+			t.Errorf("%d bytes: got %v, want %v", n, got, positions[:1])
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package code
+
+import (
+	"fmt"
+	"strings"
+	"vmlite/object"
+)
+
+// VerifyError lists every problem found in a program, each one with the
+// function and the offset of the instruction
+type VerifyError struct {
+	Problems []string
+}
+
+func (e *VerifyError) Error() string {
+	return "invalid bytecode:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Verify checks the main code and every function in the constant pool
+// before they run: opcodes must exist and be complete, operands must be in
+// range, jumps must land on an instruction and every path must reach each
+// instruction with the same stack depth, without underflowing. Main has to
+// end with an empty stack, functions have to RETURN.
+func Verify(bc *Bytecode) error {
+	v := &verifier{bc: bc}
+	v.function("main", bc.Instructions, 0, 0, true)
+	for _, c := range bc.Constants {
+		if fn, ok := c.AsObject().(*object.CompiledFunction); ok && c.Kind == object.ObjectKind {
+			v.function(fn.Name, fn.Instructions, fn.Arity, len(fn.Upvalues), false)
+		}
+	}
+	if len(v.problems) > 0 {
+		return &VerifyError{Problems: v.problems}
+	}
+	return nil
+}
+
+type verifier struct {
+	bc       *Bytecode
+	problems []string
+	name     string // function being verified
+}
+
+// a decoded instruction
+type instruction struct {
+	op       Opcode
+	operands []int
+	size     int
+}
+
+// verifies one piece of code, params are on the stack when it starts. The
+// top-level code may end without a RETURN, a function named main may not.
+func (v *verifier) function(name string, ins []byte, params int, upvalues int, topLevel bool) {
+	v.name = name
+	code := map[int]instruction{}
+	offsets := []int{}
+	ok := true
+	for ip := 0; ip < len(ins); {
+		op := ins[ip]
+		def, err := Lookup(op)
+		if err != nil {
+			v.addError(ip, err.Error())
+			return // the following offsets cannot be trusted
+		}
+		size := InstructionSize(op)
+		if ip+size > len(ins) {
+			v.addError(ip, fmt.Sprintf("truncated %s instruction", def.Name))
+			return
+		}
+		operands, _ := ReadOperands(def, ins[ip+1:])
+		code[ip] = instruction{op: op, operands: operands, size: size}
+		offsets = append(offsets, ip)
+		for i, kind := range OperandsOf(op) {
+			if msg := v.operand(op, kind, operands[i], upvalues); msg != "" {
+				v.addError(ip, msg)
+				ok = false
+			}
+		}
+		ip += size
+	}
+	for _, ip := range offsets {
+		i := code[ip]
+		if i.op != JUMP && i.op != JUMPF {
+			continue
+		}
+		if _, found := code[i.operands[0]]; !found && !(topLevel && i.operands[0] == len(ins)) {
+			v.addError(ip, fmt.Sprintf("%s target %04d is not an instruction", CodeMap[i.op], i.operands[0]))
+			ok = false
+		}
+	}
+	if ok {
+		v.stack(code, len(ins), params, upvalues, topLevel)
+	}
+}
+
+// checks the operand bounds that do not depend on the stack
+func (v *verifier) operand(op Opcode, kind OperandKind, operand int, upvalues int) string {
+	switch kind {
+	case ConstOperand, ValueOperand:
+		if operand >= len(v.bc.Constants) {
+			return fmt.Sprintf("%s constant %d out of range, the pool has %d", CodeMap[op], operand, len(v.bc.Constants))
+		}
+		if c := v.bc.Constants[operand]; !constFits(op, kind, c) {
+			return fmt.Sprintf("%s cannot use constant #%d, a %s", CodeMap[op], operand, c.Kind)
+		}
+	case NameOperand:
+		if operand >= len(v.bc.Names) {
+			return fmt.Sprintf("%s name %d out of range, there are %d names", CodeMap[op], operand, len(v.bc.Names))
+		}
+	case BuiltinOperand:
+		if operand >= len(object.Builtins) {
+			return fmt.Sprintf("LOAD_BUILTIN builtin %d out of range, there are %d builtins", operand, len(object.Builtins))
+		}
+	case UpvalueOperand:
+		if operand >= upvalues {
+			return fmt.Sprintf("%s upvalue %d out of range, the function has %d", CodeMap[op], operand, upvalues)
+		}
+	case OperatorOperand:
+		if !IsBinary(Opcode(operand)) {
+			return fmt.Sprintf("%s operator %d is not a binary operator", CodeMap[op], operand)
+		}
+	}
+	return ""
+}
+
+// follows every path through the code tracking the stack depth, relative
+// to the frame base
+func (v *verifier) stack(code map[int]instruction, end int, params int, upvalues int, topLevel bool) {
+	depths := map[int]int{0: params}
+	work := []int{0}
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		depth := depths[ip]
+		if ip == end {
+			if !topLevel {
+				v.addError(ip, "the code ends without a RETURN")
+			} else if depth != 0 {
+				v.addError(ip, fmt.Sprintf("the code ends with %d values on the stack", depth))
+			}
+			continue
+		}
+
+		i := code[ip]
+		name := CodeMap[i.op]
+		pops, pushes := stackEffect(i.op, i.operands)
+		if depth < pops {
+			v.addError(ip, fmt.Sprintf("%s needs %d values, the stack has %d", name, pops, depth))
+			return
+		}
+		switch i.op {
+		case LOAD_LOCAL, LOAD_LOCAL2, LOAD_LOCAL_CONST, LOAD_LOCAL_CONST_OP:
+			// each operand is read after the values of the previous ones
+			// are pushed, the second slot of LOAD_LOCAL2 can be the first
+			for j, kind := range OperandsOf(i.op) {
+				if kind == LocalOperand && i.operands[j] >= depth+j {
+					v.addError(ip, fmt.Sprintf("%s slot %d out of range, the stack has %d", name, i.operands[j], depth+j))
+					return
+				}
+			}
+		case STORE_LOCAL:
+			if i.operands[0] >= depth-1 {
+				v.addError(ip, fmt.Sprintf("STORE_LOCAL slot %d out of range, the stack has %d below the value", i.operands[0], depth-1))
+				return
+			}
+		case CLOSURE:
+			fn := v.bc.Constants[i.operands[0]].AsObject().(*object.CompiledFunction)
+			for _, u := range fn.Upvalues {
+				// the slot of the new closure itself can be captured
+				if u.IsLocal && u.Index > depth {
+					v.addError(ip, fmt.Sprintf("CLOSURE captures slot %d, the stack has %d", u.Index, depth+1))
+					return
+				}
+				if !u.IsLocal && u.Index >= upvalues {
+					v.addError(ip, fmt.Sprintf("CLOSURE captures upvalue %d, there are %d", u.Index, upvalues))
+					return
+				}
+			}
+		}
+		depth += pushes - pops
+
+		next := []int{}
+		switch i.op {
+		case RETURN:
+		case JUMP:
+			next = append(next, i.operands[0])
+		case JUMPF:
+			next = append(next, ip+i.size, i.operands[0])
+		default:
+			next = append(next, ip+i.size)
+		}
+		for _, n := range next {
+			d, seen := depths[n]
+			if !seen {
+				depths[n] = depth
+				work = append(work, n)
+			} else if d != depth {
+				v.addError(n, fmt.Sprintf("reached with a stack depth of %d and of %d", d, depth))
+				return
+			}
+		}
+	}
+}
+
+// the number of values an instruction pops and pushes
+func stackEffect(op Opcode, operands []int) (int, int) {
+	switch op {
+	case PUSHF, PUSHI, PUSHD, PUSHS, TRUE, FALSE, NIL,
+		LOAD, LOAD_LOCAL, LOAD_BUILTIN, LOAD_UPVALUE, CLOSURE,
+		LOAD_LOCAL_CONST_OP, LOAD_CONST_OP:
+		return 0, 1
+	case LOAD_LOCAL2, LOAD_LOCAL_CONST, LOAD_CONST:
+		return 0, 2
+	case NEGI, NEGF, NEGD, NEG, NOT:
+		return 1, 1
+	case DUP:
+		return 1, 2
+	case STORE, STORE_LOCAL, STORE_UPVALUE, CLOSE_UPVALUE, POP, POP_RESULT, PRINT, JUMPF, RETURN:
+		return 1, 0
+	case JUMP:
+		return 0, 0
+	case CALL:
+		return operands[0] + 1, 1 // the callee and the arguments
+	}
+	return 2, 1 // every other opcode is a binary operator
+}
+
+func (v *verifier) addError(ip int, msg string) {
+	v.problems = append(v.problems, fmt.Sprintf("%s, ip %04d: %s", v.name, ip, msg))
+}
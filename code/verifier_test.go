@@ -0,0 +1,105 @@
+package code
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"vmlite/object"
+)
+
+func program(ins ...[]byte) []byte {
+	return bytes.Join(ins, nil)
+}
+
+// a one instruction function that returns its parameter
+func identity(name string) object.Value {
+	return object.ObjectValue(&object.CompiledFunction{
+		Name:         name,
+		Arity:        1,
+		Instructions: program(Make(LOAD_LOCAL, 0), Make(RETURN)),
+	})
+}
+
+func TestVerify(t *testing.T) {
+	consts := []object.Value{object.IntValue(1), object.BoolValue(true), identity("f")}
+	tests := []struct {
+		name   string
+		main   []byte
+		consts []object.Value
+		want   string // part of the problem, "" when the code is valid
+	}{
+		{"valid program", program(
+			Make(CLOSURE, 2),
+			Make(PUSHI, 0),
+			Make(CALL, 1),
+			Make(PRINT),
+		), consts, ""},
+		{"valid loop", program(
+			Make(PUSHI, 0), // 0000
+			Make(DUP),      // 0005
+			Make(JUMPF, 16),
+			Make(JUMP, 5),
+			Make(POP), // 0016
+		), consts, ""},
+		{"bad opcode", program(Make(PUSHI, 0), []byte{255}), consts, "opcode 255 undefined"},
+		{"truncated instruction", Make(PUSHI, 0)[:3], consts, "truncated PUSHI instruction"},
+		{"constant out of bounds", program(Make(PUSHI, 9), Make(POP)), consts, "PUSHI constant 9 out of range, the pool has 3"},
+		{"constant of the wrong kind", program(Make(PUSHI, 1), Make(POP)), consts, "PUSHI cannot use constant #1, a bool"},
+		{"name out of bounds", program(Make(LOAD, 0), Make(POP)), consts, "LOAD name 0 out of range, there are 0 names"},
+		{"builtin out of bounds", program(Make(LOAD_BUILTIN, 999), Make(POP)), consts, "LOAD_BUILTIN builtin 999 out of range"},
+		{"jump into an instruction", program(
+			Make(PUSHI, 0), // 0000
+			Make(JUMP, 2),  // into the operand of PUSHI
+		), consts, "JUMP target 0002 is not an instruction"},
+		{"jump past the end", program(Make(JUMP, 100)), consts, "JUMP target 0100 is not an instruction"},
+		{"stack underflow", program(Make(PUSHI, 0), Make(ADD)), consts, "ADD needs 2 values, the stack has 1"},
+		{"underflow on one path", program(
+			Make(TRUE),      // 0000
+			Make(JUMPF, 12), // 0001
+			Make(PUSHI, 0),  // 0006
+			Make(POP),       // 0011
+			Make(POP),       // 0012
+		), consts, "POP needs 1 values, the stack has 0"},
+		{"inconsistent depth at a merge", program(
+			Make(TRUE),      // 0000
+			Make(JUMPF, 11), // 0001
+			Make(PUSHI, 0),  // 0006
+			Make(TRUE),      // 0011
+			Make(POP),       // 0012
+		), consts, "ip 0011: reached with a stack depth of"},
+		{"values left on the stack", program(Make(PUSHI, 0)), consts, "the code ends with 1 values on the stack"},
+		{"local slot out of range", program(Make(PUSHI, 0), Make(LOAD_LOCAL, 1), Make(POP), Make(POP)), consts, "LOAD_LOCAL slot 1 out of range"},
+		// the second load of LOAD_LOCAL2 can read the value of the first
+		{"LOAD_LOCAL2 of the slot it pushes", program(Make(PUSHI, 0), Make(LOAD_LOCAL2, 0, 1), Make(POP), Make(POP), Make(POP)), consts, ""},
+		{"LOAD_LOCAL2 slot out of range", program(Make(PUSHI, 0), Make(LOAD_LOCAL2, 0, 2), Make(POP), Make(POP), Make(POP)), consts, "LOAD_LOCAL2 slot 2 out of range, the stack has 2"},
+		{"fused operator", program(Make(PUSHI, 0), Make(LOAD_LOCAL_CONST_OP, 0, 0, int(ADDI)), Make(POP), Make(POP)), consts, ""},
+		{"fused operator that is not binary", program(Make(PUSHI, 0), Make(LOAD_LOCAL_CONST_OP, 0, 0, int(NEGI)), Make(POP), Make(POP)), consts, "LOAD_LOCAL_CONST_OP operator 11 is not a binary operator"},
+		{"fused operator slot out of range", program(Make(LOAD_LOCAL_CONST_OP, 0, 0, int(ADDI)), Make(POP)), consts, "LOAD_LOCAL_CONST_OP slot 0 out of range, the stack has 0"},
+		{"function without RETURN", program(), []object.Value{object.ObjectValue(&object.CompiledFunction{
+			Name: "g", Instructions: program(Make(NIL), Make(POP)),
+		})}, "g, ip 0002: the code ends without a RETURN"},
+		// only the top-level code may end without a RETURN, whatever the name
+		{"function named main without RETURN", program(), []object.Value{object.ObjectValue(&object.CompiledFunction{
+			Name: "main", Instructions: program(Make(NIL), Make(POP)),
+		})}, "main, ip 0002: the code ends without a RETURN"},
+		{"function named main jumping to its end", program(), []object.Value{object.ObjectValue(&object.CompiledFunction{
+			Name: "main", Instructions: program(Make(JUMP, 5)),
+		})}, "JUMP target 0005 is not an instruction"},
+	}
+	for _, tt := range tests {
+		err := Verify(&Bytecode{Version: VERSION, Instructions: tt.main, Constants: tt.consts, Names: []string{}})
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: no error, want %q", tt.name, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package compiler
+
+import (
+	"fmt"
+	"vmlite/ast"
+	"vmlite/object"
+	"vmlite/token"
+)
+
+// Type is what the checker knows statically about a value
+type Type byte
+
+const (
+	Unknown Type = iota // nothing inferred yet
+	Int
+	Decimal
+	Float
+	String
+	Bool
+	Dynamic // only known at run time, the compiler emits generic opcodes
+)
+
+var typeNames = []string{"unknown", "int", "decimal", "float", "string", "bool", "dynamic"}
+
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+// joins two inferred types: a variable holding values of different types
+// can only be typed at run time
+func join(t1 Type, t2 Type) Type {
+	if t1 == Unknown {
+		return t2
+	}
+	if t2 == Unknown || t1 == t2 {
+		return t1
+	}
+	return Dynamic
+}
+
+// Types is the result of the checker, the compiler uses it to pick
+// specialized or generic opcodes.
+type Types struct {
+	exprs    map[ast.Expr]Type
+	counters map[*ast.ForStmt]Type // type of each 'for' counter
+	targets  map[*ast.Assign]Type  // type of each assigned variable
+}
+
+func (t *Types) TypeOf(expr ast.Expr) Type {
+	return known(t.exprs[expr])
+}
+
+func (t *Types) CounterType(stmt *ast.ForStmt) Type {
+	return known(t.counters[stmt])
+}
+
+func (t *Types) TargetType(expr *ast.Assign) Type {
+	return known(t.targets[expr])
+}
+
+// anything that is still unknown after checking is left to the VM
+func known(t Type) Type {
+	if t == Unknown {
+		return Dynamic
+	}
+	return t
+}
+
+// the inferred type of a variable, shared by every pass
+type varType struct {
+	t      Type
+	global bool // globals may change from any REPL line, they stay dynamic
+}
+
+// Checker infers the type of every expression and variable. Local
+// variables get the join of every value assigned to them anywhere in their
+// scope, so the program is checked again until no variable type changes.
+type Checker struct {
+	types   *Types
+	vars    map[interface{}]*varType // keyed by the declaring node
+	scopes  []map[string]*varType
+	names   []string        // globals of the previous REPL lines
+	globals map[string]bool // globals the compiler knows at this point
+	changed bool
+	report  bool // only the last pass reports errors
+	errors  []string
+}
+
+func NewChecker(co_names []string) *Checker {
+	return &Checker{
+		names: co_names,
+		types: &Types{
+			exprs:    make(map[ast.Expr]Type),
+			counters: make(map[*ast.ForStmt]Type),
+			targets:  make(map[*ast.Assign]Type),
+		},
+		vars:   make(map[interface{}]*varType),
+		errors: []string{},
+	}
+}
+
+func (c *Checker) Check(program []ast.Stmt) *Types {
+	for {
+		c.changed = false
+		c.pass(program)
+		if !c.changed {
+			break
+		}
+	}
+	c.report = true
+	c.pass(program)
+	return c.types
+}
+
+func (c *Checker) Errors() []string {
+	return c.errors
+}
+
+func (c *Checker) pass(program []ast.Stmt) {
+	c.scopes = []map[string]*varType{}
+	// like the compiler: the previous globals and the top-level functions
+	// are defined before the first statement
+	c.globals = map[string]bool{}
+	for _, name := range c.names {
+		c.globals[name] = true
+	}
+	for _, stmt := range program {
+		if fn, ok := stmt.(*ast.FuncStmt); ok {
+			c.globals[fn.Name.Lexeme.(string)] = true
+		}
+	}
+	for _, stmt := range program {
+		c.executeStmt(stmt)
+	}
+}
+
+// Statements Visitor and Executor
+func (c *Checker) executeStmt(stmt ast.Stmt) interface{} {
+	return stmt.Accept(c)
+}
+
+func (c *Checker) VisitVarStmt(stmt *ast.VarStmt) interface{} {
+	t := c.evaluateExpr(stmt.Value)
+	c.assign(c.declare(stmt, stmt.Name), t)
+	return nil
+}
+
+func (c *Checker) VisitExprStmt(stmt *ast.ExprStmt) interface{} {
+	c.evaluateExpr(stmt.Expression)
+	return nil
+}
+
+func (c *Checker) VisitPrintStmt(stmt *ast.PrintStmt) interface{} {
+	c.evaluateExpr(stmt.Value)
+	return nil
+}
+
+func (c *Checker) VisitIfStmt(stmt *ast.IfStmt) interface{} {
+	c.condition(stmt.Keyword, stmt.Condition)
+	c.checkBlock(stmt.ThenBranch)
+	c.checkBlock(stmt.ElseBranch)
+	return nil
+}
+
+func (c *Checker) VisitWhileStmt(stmt *ast.WhileStmt) interface{} {
+	c.condition(stmt.Keyword, stmt.Condition)
+	c.checkBlock(stmt.Body)
+	return nil
+}
+
+func (c *Checker) VisitForStmt(stmt *ast.ForStmt) interface{} {
+	bounds := []ast.Expr{stmt.Start, stmt.Limit}
+	if stmt.Step != nil {
+		bounds = append(bounds, stmt.Step)
+	}
+	for _, e := range bounds {
+		if t := c.evaluateExpr(e); t == String || t == Bool {
+			c.addError(stmt.Name, fmt.Sprintf("'for' bounds must be numbers, got %v", t))
+		}
+	}
+
+	// the counter starts with the initial value and is then incremented,
+	// the compiler reuses a visible variable and only declares a new one
+	// when there is none
+	name := stmt.Name.Lexeme.(string)
+	v := c.lookup(name)
+	if v == nil && c.globals[name] {
+		v = &varType{t: Dynamic, global: true}
+	} else if v == nil {
+		v = c.declare(stmt, stmt.Name)
+	}
+	step := Int
+	if stmt.Step != nil {
+		step = c.types.exprs[stmt.Step]
+	}
+	c.assign(v, c.types.exprs[stmt.Start])
+	c.assign(v, c.binary(stmt.Name, token.PLUS, v.t, step))
+
+	c.checkBlock(stmt.Body)
+	c.types.counters[stmt] = v.t
+	return nil
+}
+
+func (c *Checker) VisitBreakStmt(stmt *ast.BreakStmt) interface{} {
+	return nil
+}
+
+func (c *Checker) VisitContinueStmt(stmt *ast.ContinueStmt) interface{} {
+	return nil
+}
+
+func (c *Checker) VisitBlockStmt(stmt *ast.BlockStmt) interface{} {
+	c.checkBlock(stmt.Statements)
+	return nil
+}
+
+func (c *Checker) VisitFuncStmt(stmt *ast.FuncStmt) interface{} {
+	c.assign(c.declare(stmt, stmt.Name), Dynamic)
+
+	c.beginScope()
+	for i := range stmt.Params {
+		// arguments can be anything
+		c.assign(c.declare(&stmt.Params[i], stmt.Params[i]), Dynamic)
+	}
+	for _, s := range stmt.Body {
+		c.executeStmt(s)
+	}
+	c.endScope()
+	return nil
+}
+
+func (c *Checker) VisitReturnStmt(stmt *ast.ReturnStmt) interface{} {
+	if stmt.Value != nil {
+		c.evaluateExpr(stmt.Value)
+	}
+	return nil
+}
+
+func (c *Checker) checkBlock(block []ast.Stmt) {
+	c.beginScope()
+	for _, stmt := range block {
+		c.executeStmt(stmt)
+	}
+	c.endScope()
+}
+
+// Expressions Visitor and Evaluator
+func (c *Checker) evaluateExpr(expr ast.Expr) Type {
+	t := expr.Accept(c).(Type)
+	c.types.exprs[expr] = t
+	return t
+}
+
+func (c *Checker) VisitUnaryExpr(expr *ast.Unary) interface{} {
+	t := c.evaluateExpr(expr.Right)
+	switch expr.Operator.Type {
+	case token.MINUS:
+		if t == String || t == Bool {
+			c.addError(expr.Operator, "the [minus] operator only works with numeric types")
+			return Dynamic
+		}
+		return t
+	case token.NOT:
+		if numeric(t) || t == String {
+			c.addError(expr.Operator, "the [not] operator only works with boolean types")
+		}
+		return Bool
+	}
+	return Dynamic
+}
+
+func (c *Checker) VisitBinaryExpr(expr *ast.Binary) interface{} {
+	left := c.evaluateExpr(expr.Left)
+	right := c.evaluateExpr(expr.Right)
+	return c.binary(expr.Operator, expr.Operator.Type, left, right)
+}
+
+func (c *Checker) VisitCallExpr(expr *ast.Call) interface{} {
+	c.evaluateExpr(expr.Callee)
+	for _, arg := range expr.Arguments {
+		c.evaluateExpr(arg)
+	}
+	return Dynamic
+}
+
+func (c *Checker) VisitAssignExpr(expr *ast.Assign) interface{} {
+	t := c.evaluateExpr(expr.Value)
+	v := c.lookup(expr.Name.Lexeme.(string))
+	target := Dynamic
+	if v != nil {
+		target = v.t
+	}
+	c.types.targets[expr] = target
+
+	if op, ok := compoundOps[expr.Operator.Type]; ok {
+		t = c.binary(expr.Operator, op, target, t)
+	}
+	if v != nil {
+		c.assign(v, t)
+	}
+	return t
+}
+
+func (c *Checker) VisitIdentifierExpr(expr *ast.Identifier) interface{} {
+	if v := c.lookup(expr.Value.Lexeme.(string)); v != nil {
+		return v.t
+	}
+	// globals from previous lines, builtins and undefined names
+	return Dynamic
+}
+
+func (c *Checker) VisitLiteralExpr(expr *ast.Literal) interface{} {
+	switch expr.Token.Type {
+	case token.NUMBER:
+		switch expr.Token.Lexeme.(type) {
+		case int64:
+			return Int
+		case object.Decimal:
+			return Decimal
+		}
+		return Float
+	case token.STRING:
+		return String
+	case token.TRUE, token.FALSE:
+		return Bool
+	}
+	return Dynamic
+}
+
+/****************************
+* CHECKER HELPER FUNCTIONS
+*****************************/
+
+// infers the result of a binary operation, reporting invalid operands
+// when both types are known
+func (c *Checker) binary(operator token.Token, op token.TokenType, left Type, right Type) Type {
+	if left == Unknown || right == Unknown {
+		return Unknown // wait for a later pass
+	}
+	if left == Dynamic || right == Dynamic {
+		switch op {
+		case token.LT, token.GT, token.LEQ, token.GEQ, token.EQ, token.NEQ, token.AND, token.OR:
+			return Bool
+		}
+		return Dynamic // any number, '+' and '-' also work on strings
+	}
+	if op == token.EQ || op == token.NEQ {
+		return Bool // any two values can be compared for equality
+	}
+	if numeric(left) && numeric(right) {
+		switch op {
+		case token.PLUS, token.MINUS, token.MUL, token.IDIV, token.MOD:
+			return promote(left, right)
+		case token.DIV:
+			if left == Int && right == Int {
+				return Float
+			}
+			return promote(left, right)
+		case token.LT, token.GT, token.LEQ, token.GEQ:
+			return Bool
+		}
+		c.addError(operator, "unsupported operator for numeric types")
+		return Dynamic
+	}
+	if left != right {
+		c.addError(operator, fmt.Sprintf("invalid operands %v and %v", left, right))
+		return Dynamic
+	}
+
+	switch left {
+	case String:
+		switch op {
+		case token.PLUS, token.MINUS:
+			return String
+		case token.LT, token.GT, token.LEQ, token.GEQ:
+			return Bool
+		}
+		c.addError(operator, "unsupported operator for string type")
+	case Bool:
+		if op == token.AND || op == token.OR {
+			return Bool
+		}
+		c.addError(operator, "unsupported operator for boolean type")
+	}
+	return Dynamic
+}
+
+func numeric(t Type) bool {
+	return t == Int || t == Decimal || t == Float
+}
+
+// ints are promoted to decimals, both to floats
+func promote(left Type, right Type) Type {
+	if left > right {
+		return left
+	}
+	return right
+}
+
+func (c *Checker) condition(keyword token.Token, expr ast.Expr) {
+	if t := c.evaluateExpr(expr); numeric(t) || t == String {
+		c.addError(keyword, fmt.Sprintf("condition must be a boolean, got %v", t))
+	}
+}
+
+func (c *Checker) beginScope() {
+	c.scopes = append(c.scopes, map[string]*varType{})
+}
+
+func (c *Checker) endScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// declares the variable introduced by node, top-level names are globals
+func (c *Checker) declare(node interface{}, name token.Token) *varType {
+	v, ok := c.vars[node]
+	if !ok {
+		v = &varType{global: len(c.scopes) == 0}
+		if v.global {
+			v.t = Dynamic
+		}
+		c.vars[node] = v
+	}
+	if v.global {
+		c.globals[name.Lexeme.(string)] = true
+	} else {
+		c.scopes[len(c.scopes)-1][name.Lexeme.(string)] = v
+	}
+	return v
+}
+
+// finds a local variable, globals are not tracked
+func (c *Checker) lookup(name string) *varType {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if v, ok := c.scopes[i][name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func (c *Checker) assign(v *varType, t Type) {
+	if j := join(v.t, t); j != v.t {
+		v.t = j
+		c.changed = true
+	}
+}
+
+func (c *Checker) addError(tok token.Token, msg string) {
+	if c.report {
+		c.errors = append(c.errors, fmt.Sprintf("%s at Ln: %d, Col: %d.", msg, tok.Ln, tok.Col))
+	}
+}
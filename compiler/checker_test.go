@@ -0,0 +1,56 @@
+package compiler
+
+import (
+	"testing"
+	"vmlite/ast"
+	"vmlite/lexer"
+	"vmlite/parser"
+)
+
+// finds the first 'for' statement of a program
+func findFor(stmts []ast.Stmt) *ast.ForStmt {
+	for _, stmt := range stmts {
+		var inner []ast.Stmt
+		switch s := stmt.(type) {
+		case *ast.ForStmt:
+			return s
+		case *ast.BlockStmt:
+			inner = s.Statements
+		case *ast.FuncStmt:
+			inner = s.Body
+		}
+		if f := findFor(inner); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// the counter has the type of the variable the compiler stores it in
+func TestForCounterScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string // globals of previous lines
+		src   string
+		want  Type
+	}{
+		{"new local", nil, `func f() for i = 1 to 3 print i endfor end`, Int},
+		{"new global", nil, `for i = 1 to 3 print i endfor`, Dynamic},
+		{"global in a block", nil, `var i = 0 do for i = 1 to 3 print i endfor end`, Dynamic},
+		{"global in a function", nil, `var i = 0 func f() for i = 1 to 3 print i endfor end`, Dynamic},
+		{"global of a previous line", []string{"i"}, `do for i = 1 to 3 print i endfor end`, Dynamic},
+		{"global declared later", nil, `func f() for i = 1 to 3 print i endfor end var i = 0`, Int},
+		{"enclosing local", nil, `func f() var i = 0 for i = 1 to 3 print i endfor end`, Int},
+	}
+	for _, tt := range tests {
+		p := parser.NewParser(lexer.NewLexer(tt.src))
+		program := p.Program()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+		types := NewChecker(tt.names).Check(program)
+		if got := types.CounterType(findFor(program)); got != tt.want {
+			t.Errorf("%s: counter type %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -4,39 +4,85 @@ import (
 	"fmt"
 	"vmlite/ast"
 	"vmlite/code"
+	"vmlite/object"
 	"vmlite/token"
 )
 
 type Compiler struct {
-	co_code   []code.Opcode
-	co_names  []string
-	co_consts []interface{}
+	scope     *compilationScope
+	co_consts []object.Value
+	constants map[object.ConstKey]int // index of each constant in co_consts
 	co_values []interface{}
 	errors    []string
-	lop       byte
+	types     *Types
+}
+
+// the state of the function being compiled, the top-level code included
+type compilationScope struct {
+	co_code   []code.Opcode
+	lines     []code.Position // where each run of instructions comes from
+	ln, col   int             // position of the instructions emitted next
+	loops     []*loop
+	symbols   *SymbolTable
+	function  bool // inside a 'func' body, 'return' is allowed
+	enclosing *compilationScope
+}
+
+// jumps emitted by break/continue that are patched once the loop is compiled
+type loop struct {
+	breaks    []int
+	continues []int
+	depth     int // scope depth the loop was entered at
 }
 
-func NewCompiler(co_names []string, co_consts []interface{}) *Compiler {
+func NewCompiler(co_names []string, co_consts []object.Value) *Compiler {
 	c := &Compiler{
-		co_code:   []code.Opcode{},
-		co_names:  co_names,
+		scope: &compilationScope{
+			co_code: []code.Opcode{},
+			symbols: NewSymbolTable(co_names),
+		},
 		co_consts: co_consts,
+		constants: map[object.ConstKey]int{},
 		co_values: []interface{}{},
 		errors:    []string{},
 	}
+	// the REPL passes the pool of the previous lines back in
+	for i, cons := range co_consts {
+		if key, ok := cons.Key(); ok {
+			if _, found := c.constants[key]; !found {
+				c.constants[key] = i
+			}
+		}
+	}
 	return c
 }
 
 func (c *Compiler) GetCodes() []code.Opcode {
-	return c.co_code
+	return c.scope.co_code
 }
 
-func (c *Compiler) GetConstants() []interface{} {
+func (c *Compiler) GetConstants() []object.Value {
 	return c.co_consts
 }
 
 func (c *Compiler) GetNames() []string {
-	return c.co_names
+	return c.scope.symbols.Names()
+}
+
+// GetLines returns the line table of the top-level code
+func (c *Compiler) GetLines() []byte {
+	return code.EncodeLines(c.scope.lines)
+}
+
+// Bytecode packs the compiled program with the encoding version it uses
+func (c *Compiler) Bytecode() *code.Bytecode {
+	return &code.Bytecode{
+		Version:      code.VERSION,
+		Instructions: c.GetCodes(),
+		Constants:    c.GetConstants(),
+		Names:        c.GetNames(),
+		Lines:        c.GetLines(),
+	}
 }
 
 func (c *Compiler) Errors() []string {
@@ -44,6 +90,17 @@ func (c *Compiler) Errors() []string {
 }
 
 func (c *Compiler) Compile(program []ast.Stmt) {
+	checker := NewChecker(c.scope.symbols.Names())
+	c.types = checker.Check(program)
+	c.errors = append(c.errors, checker.Errors()...)
+
+	// top-level functions are globals from the start so they can call each
+	// other in any order
+	for _, stmt := range program {
+		if fn, ok := stmt.(*ast.FuncStmt); ok {
+			c.scope.symbols.Define(fn.Name.Lexeme.(string))
+		}
+	}
 	for _, stmt := range program {
 		c.executeStmt(stmt)
 	}
@@ -56,14 +113,22 @@ func (c *Compiler) executeStmt(stmt ast.Stmt) interface{} {
 
 func (c *Compiler) VisitVarStmt(stmt *ast.VarStmt) interface{} {
 	c.evaluateExpr(stmt.Value)
-	i := c.addName(stmt.Name.Lexeme.(string))
-
-	c.emit(code.STORE, float32(i))
+	c.at(stmt.Name)
+	c.declareVariable(stmt.Name)
 	return nil
 }
 
 func (c *Compiler) VisitExprStmt(stmt *ast.ExprStmt) interface{} {
-	return c.evaluateExpr(stmt.Expression)
+	c.evaluateExpr(stmt.Expression)
+	// the REPL shows the value of a top-level expression, not of an
+	// assignment, everywhere else it is just dropped to keep the stack
+	// balanced, locals live on it
+	if _, assign := stmt.Expression.(*ast.Assign); !assign && !c.scope.function && c.scope.symbols.Depth() == 0 {
+		c.emit(code.POP_RESULT)
+	} else {
+		c.emit(code.POP)
+	}
+	return nil
 }
 
 func (c *Compiler) VisitPrintStmt(stmt *ast.PrintStmt) interface{} {
@@ -72,6 +137,194 @@ func (c *Compiler) VisitPrintStmt(stmt *ast.PrintStmt) interface{} {
 	return nil
 }
 
+func (c *Compiler) VisitIfStmt(stmt *ast.IfStmt) interface{} {
+	c.evaluateExpr(stmt.Condition)
+	c.at(stmt.Keyword)
+	jumpFalse := c.emit(code.JUMPF, 0) // target is patched below
+
+	c.compileBlock(stmt.ThenBranch)
+	if stmt.ElseBranch == nil {
+		c.patchJump(jumpFalse)
+		return nil
+	}
+	jumpEnd := c.emit(code.JUMP, 0)
+	c.patchJump(jumpFalse)
+
+	c.compileBlock(stmt.ElseBranch)
+	c.patchJump(jumpEnd)
+	return nil
+}
+
+func (c *Compiler) VisitWhileStmt(stmt *ast.WhileStmt) interface{} {
+	start := len(c.scope.co_code)
+	c.evaluateExpr(stmt.Condition)
+	c.at(stmt.Keyword)
+	jumpFalse := c.emit(code.JUMPF, 0)
+
+	l := c.enterLoop()
+	c.compileBlock(stmt.Body)
+	c.exitLoop()
+
+	c.patchJumps(l.continues, start)
+	c.at(stmt.Keyword)
+	c.emit(code.JUMP, start)
+	c.patchJump(jumpFalse)
+	c.patchJumps(l.breaks, len(c.scope.co_code))
+	return nil
+}
+
+func (c *Compiler) VisitForStmt(stmt *ast.ForStmt) interface{} {
+	// the counter is an ordinary variable of the enclosing scope
+	c.evaluateExpr(stmt.Start)
+	c.at(stmt.Name)
+	counter, ok := c.scope.symbols.Resolve(stmt.Name.Lexeme.(string))
+	if ok && counter.Scope != BuiltinScope {
+		c.storeVariable(counter)
+	} else {
+		counter = c.declareVariable(stmt.Name)
+	}
+
+	// the limit and the step are evaluated once and kept in hidden locals
+	// that can never clash with a user identifier.
+	c.beginScope()
+	c.evaluateExpr(stmt.Limit)
+	c.at(stmt.Name)
+	limit := c.scope.symbols.DefineLocal("for$limit")
+	if stmt.Step != nil {
+		c.evaluateExpr(stmt.Step)
+		c.at(stmt.Name)
+	} else {
+		c.emit(code.PUSHI, c.addConstant(object.IntValue(1)))
+	}
+	step := c.scope.symbols.DefineLocal("for$step")
+
+	t := c.types.CounterType(stmt)
+	limitType := c.types.TypeOf(stmt.Limit)
+	stepType := Int
+	if stmt.Step != nil {
+		stepType = c.types.TypeOf(stmt.Step)
+	}
+
+	// a positive step counts up to the limit, a negative one counts down:
+	// step >= 0 ? counter <= limit : counter >= limit
+	start := len(c.scope.co_code)
+	c.loadVariable(step)
+	c.emit(code.PUSHI, c.addConstant(object.IntValue(0)))
+	c.emit(binaryOp(token.GEQ, stepType, Int))
+	countDown := c.emit(code.JUMPF, 0)
+	c.emitCompare(counter, binaryOp(token.LEQ, t, limitType), limit)
+	test := c.emit(code.JUMP, 0)
+	c.patchJump(countDown)
+	c.emitCompare(counter, binaryOp(token.GEQ, t, limitType), limit)
+	c.patchJump(test)
+	jumpFalse := c.emit(code.JUMPF, 0)
+
+	l := c.enterLoop()
+	c.compileBlock(stmt.Body)
+	c.exitLoop()
+
+	// counter = counter + step
+	c.patchJumps(l.continues, len(c.scope.co_code))
+	c.at(stmt.Name)
+	c.loadVariable(counter)
+	c.loadVariable(step)
+	c.emit(binaryOp(token.PLUS, t, stepType))
+	c.storeVariable(counter)
+	c.emit(code.JUMP, start)
+
+	c.patchJump(jumpFalse)
+	c.patchJumps(l.breaks, len(c.scope.co_code))
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) VisitBreakStmt(stmt *ast.BreakStmt) interface{} {
+	if len(c.scope.loops) == 0 {
+		c.addError(fmt.Sprintf("'%v' outside of a loop at Ln: %d, Col: %d.", stmt.Keyword.Lexeme, stmt.Keyword.Ln, stmt.Keyword.Col))
+		return nil
+	}
+	l := c.scope.loops[len(c.scope.loops)-1]
+	c.at(stmt.Keyword)
+	c.popLocals(l.depth)
+	l.breaks = append(l.breaks, c.emit(code.JUMP, 0))
+	return nil
+}
+
+func (c *Compiler) VisitContinueStmt(stmt *ast.ContinueStmt) interface{} {
+	if len(c.scope.loops) == 0 {
+		c.addError(fmt.Sprintf("'%v' outside of a loop at Ln: %d, Col: %d.", stmt.Keyword.Lexeme, stmt.Keyword.Ln, stmt.Keyword.Col))
+		return nil
+	}
+	l := c.scope.loops[len(c.scope.loops)-1]
+	c.at(stmt.Keyword)
+	c.popLocals(l.depth)
+	l.continues = append(l.continues, c.emit(code.JUMP, 0))
+	return nil
+}
+
+func (c *Compiler) VisitBlockStmt(stmt *ast.BlockStmt) interface{} {
+	c.compileBlock(stmt.Statements)
+	return nil
+}
+
+func (c *Compiler) VisitFuncStmt(stmt *ast.FuncStmt) interface{} {
+	// the name is declared before the body so the function can call itself,
+	// a local slot is filled by the CLOSURE below
+	sym := c.defineSymbol(stmt.Name)
+
+	c.enterFunction()
+	c.at(stmt.Name)
+	for _, param := range stmt.Params {
+		c.declareVariable(param)
+	}
+	for _, s := range stmt.Body {
+		c.executeStmt(s)
+	}
+	c.emit(code.NIL) // implicit 'return'
+	c.emit(code.RETURN)
+	upvalues := c.scope.symbols.Upvalues()
+	lines := code.EncodeLines(c.scope.lines)
+	fn := &object.CompiledFunction{
+		Name:         sym.Name,
+		Arity:        len(stmt.Params),
+		Instructions: c.leaveFunction(),
+		Upvalues:     upvalues,
+		Lines:        lines,
+	}
+
+	c.at(stmt.Name)
+	c.emit(code.CLOSURE, c.addConstant(object.ObjectValue(fn)))
+	if sym.Scope == GlobalScope {
+		c.emit(code.STORE, sym.Index)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitReturnStmt(stmt *ast.ReturnStmt) interface{} {
+	if !c.scope.function {
+		c.addError("'return' outside of a function.")
+		return nil
+	}
+	c.at(stmt.Keyword)
+	if stmt.Value != nil {
+		c.evaluateExpr(stmt.Value)
+		c.at(stmt.Keyword)
+	} else {
+		c.emit(code.NIL)
+	}
+	c.emit(code.RETURN)
+	return nil
+}
+
+// compiles the statements inside a new lexical scope
+func (c *Compiler) compileBlock(block []ast.Stmt) {
+	c.beginScope()
+	for _, stmt := range block {
+		c.executeStmt(stmt)
+	}
+	c.endScope()
+}
+
 // Expressions Visitor and Evaluator
 func (c *Compiler) evaluateExpr(expr ast.Expr) interface{} {
 	return expr.Accept(c)
@@ -79,116 +332,109 @@ func (c *Compiler) evaluateExpr(expr ast.Expr) interface{} {
 
 func (c *Compiler) VisitUnaryExpr(expr *ast.Unary) interface{} {
 	c.evaluateExpr(expr.Right)
-	op := c.lop
-	c.emit(code.UNARY)
+	c.at(expr.Operator)
 	switch expr.Operator.Type {
 	case token.MINUS:
-		if typeOf(op) == 'f' {
-			c.emit(code.UNEG)
-		} else {
-			c.addError("the [minus] operator only works with numeric types.")
+		switch c.types.TypeOf(expr.Right) {
+		case Int:
+			c.emit(code.NEGI)
+		case Decimal:
+			c.emit(code.NEGD)
+		case Float:
+			c.emit(code.NEGF)
+		default:
+			c.emit(code.NEG)
 		}
 	case token.NOT:
-		if typeOf(op) == 'l' {
-			c.emit(code.NOT)
-		} else {
-			c.addError("the [not] operator only works with boolean types.")
-		}
+		c.emit(code.NOT)
 	}
 	return nil
 }
 
 func (c *Compiler) VisitBinaryExpr(expr *ast.Binary) interface{} {
 	c.evaluateExpr(expr.Left)
-	op1 := c.lop
 	c.evaluateExpr(expr.Right)
-	op2 := c.lop
-
-	// DEBUG
-	fmt.Printf("leftOp: %v, rightOp: %v typeLeft: %v, typeRight: %v\n", code.CodeMap[op1], code.CodeMap[op2], string(typeOf(op1)), string(typeOf(op2)))
-	// DEBUG
-
-	c.emit(code.CMP) // COMPARE
-	t := expr.Operator.Type
-	if typeOf(op1) == 's' && typeOf(op2) == 's' {
-		switch t {
-		case token.PLUS:
-			c.emit(code.ADDS)
-		case token.MINUS:
-			c.emit(code.SUBS)
-		default:
-			c.addError("unsupported operator for string type.")
-		}
-	} else if typeOf(op1) == 'f' && typeOf(op2) == 'f' {
-		switch t {
-		case token.PLUS:
-			c.emit(code.ADD)
-		case token.MINUS:
-			c.emit(code.SUB)
-		case token.MUL:
-			c.emit(code.MUL)
-		case token.DIV:
-			c.emit(code.DIV)
-		case token.LT:
-			c.emit(code.LT)
-		case token.GT:
-			c.emit(code.GT)
-		case token.LEQ:
-			c.emit(code.LEQ)
-		case token.GEQ:
-			c.emit(code.GEQ)
-		case token.EQ:
-			c.emit(code.EQ)
-		case token.NEQ:
-			c.emit(code.NEQ)
-		default:
-			c.addError("unsupported operator for string type.")
-		}
-	} else if typeOf(op1) == 'l' && typeOf(op2) == 'l' {
-		switch t {
-		case token.AND:
-			c.emit(code.AND)
-		case token.OR:
-			c.emit(code.OR)
-		default:
-			c.addError("unsupported operator for boolean type.")
-		}
+
+	c.at(expr.Operator)
+	c.emit(binaryOp(expr.Operator.Type, c.types.TypeOf(expr.Left), c.types.TypeOf(expr.Right)))
+	return nil
+}
+
+func (c *Compiler) VisitCallExpr(expr *ast.Call) interface{} {
+	c.evaluateExpr(expr.Callee)
+	for _, arg := range expr.Arguments {
+		c.evaluateExpr(arg)
+	}
+	c.at(expr.Paren)
+	c.emit(code.CALL, len(expr.Arguments))
+	return nil
+}
+
+// binary operator applied by each compound assignment
+var compoundOps = map[token.TokenType]token.TokenType{
+	token.PLUS_ASSIGN:  token.PLUS,
+	token.MINUS_ASSIGN: token.MINUS,
+	token.MUL_ASSIGN:   token.MUL,
+	token.DIV_ASSIGN:   token.DIV,
+}
+
+func (c *Compiler) VisitAssignExpr(expr *ast.Assign) interface{} {
+	sym, ok := c.resolve(expr.Name)
+	if !ok {
+		return nil
+	}
+	if sym.Scope == BuiltinScope {
+		c.addError(fmt.Sprintf("cannot assign to builtin '%s' at Ln: %d, Col: %d.", sym.Name, expr.Name.Ln, expr.Name.Col))
+		return nil
+	}
+
+	if op, ok := compoundOps[expr.Operator.Type]; ok {
+		// x += v is compiled as x = x + v
+		c.at(expr.Name)
+		c.loadVariable(sym)
+		c.evaluateExpr(expr.Value)
+		c.at(expr.Operator)
+		c.emit(binaryOp(op, c.types.TargetType(expr), c.types.TypeOf(expr.Value)))
 	} else {
-		c.addError("invalid operands.")
+		c.evaluateExpr(expr.Value)
+	}
+	c.at(expr.Operator)
+	c.emit(code.DUP) // the assignment is an expression, its value stays
+	c.storeVariable(sym)
+	return nil
+}
+
+func (c *Compiler) VisitIdentifierExpr(expr *ast.Identifier) interface{} {
+	if sym, ok := c.resolve(expr.Value); ok {
+		c.at(expr.Value)
+		c.loadVariable(sym)
 	}
 	return nil
 }
 
 func (c *Compiler) VisitLiteralExpr(expr *ast.Literal) interface{} {
 	t := expr.Token.Type
+	c.at(expr.Token)
 	switch t {
 	case token.TRUE, token.FALSE:
-		c.emit(code.BOOL)
 		if t == token.TRUE {
 			c.emit(code.TRUE)
 		} else {
 			c.emit(code.FALSE)
 		}
 	case token.STRING:
-		i := c.addConstant(expr.Token.Lexeme.(string))
-		c.emit(code.PUSHS, float32(i))
-
-	case token.IDENT:
-		name := expr.Token.Lexeme.(string)
-		i := -1
-		for j := 0; j < len(c.co_names); j++ {
-			if c.co_names[j] == name {
-				i = j
-				break
-			}
-		}
-		if i < 0 {
-			c.addError(fmt.Sprintf("Variable not defined: %s\n", name))
-			return nil
-		}
-		c.emit(code.LOAD, float32(i))
+		i := c.addConstant(object.Intern(expr.Token.Lexeme.(string)))
+		c.emit(code.PUSHS, i)
+
 	case token.NUMBER:
-		c.emit(code.PUSHF, expr.Token.Lexeme.(float32))
+		switch v := expr.Token.Lexeme.(type) {
+		case int64:
+			c.emit(code.PUSHI, c.addConstant(object.IntValue(v)))
+		case object.Decimal:
+			c.emit(code.PUSHD, c.addConstant(object.DecimalValue(v)))
+		case float64:
+			c.emit(code.PUSHF, c.addConstant(object.FloatValue(v)))
+		}
 	}
 	return nil
 }
@@ -197,37 +443,266 @@ func (c *Compiler) VisitLiteralExpr(expr *ast.Literal) interface{} {
 * COMPILER HELPER FUNCTIONS
 *****************************/
 
-// emits a byte instruction
-func (c *Compiler) emit(op code.Opcode, args ...float32) int {
-	ins := code.Make(op, args...)
-	i := len(c.co_code)
-	c.co_code = append(c.co_code, ins...)
+// specialized opcodes, used when both operands have the same known type
+var intOps = map[token.TokenType]code.Opcode{
+	token.PLUS:  code.ADDI,
+	token.MINUS: code.SUBI,
+	token.MUL:   code.MULI,
+	token.IDIV:  code.IDIVI,
+	token.MOD:   code.MODI,
+	token.LT:    code.LTI,
+	token.GT:    code.GTI,
+	token.LEQ:   code.LEQI,
+	token.GEQ:   code.GEQI,
+	token.EQ:    code.EQI,
+	token.NEQ:   code.NEQI,
+}
 
-	c.lop = op // save last op
+var decimalOps = map[token.TokenType]code.Opcode{
+	token.PLUS:  code.ADDD,
+	token.MINUS: code.SUBD,
+	token.MUL:   code.MULD,
+	token.DIV:   code.DIVD,
+	token.IDIV:  code.IDIVD,
+	token.MOD:   code.MODD,
+	token.LT:    code.LTD,
+	token.GT:    code.GTD,
+	token.LEQ:   code.LEQD,
+	token.GEQ:   code.GEQD,
+	token.EQ:    code.EQD,
+	token.NEQ:   code.NEQD,
+}
 
-	return i
+var floatOps = map[token.TokenType]code.Opcode{
+	token.PLUS:  code.ADDF,
+	token.MINUS: code.SUBF,
+	token.MUL:   code.MULF,
+	token.DIV:   code.DIVF,
+	token.IDIV:  code.IDIVF,
+	token.MOD:   code.MODF,
+	token.LT:    code.LTF,
+	token.GT:    code.GTF,
+	token.LEQ:   code.LEQF,
+	token.GEQ:   code.GEQF,
+	token.EQ:    code.EQF,
+	token.NEQ:   code.NEQF,
 }
 
-// add a constant in co_consts array
-func (c *Compiler) addConstant(cons interface{}) int {
-	var i int = len(c.co_consts)
-	c.co_consts = append(c.co_consts, cons)
+var stringOps = map[token.TokenType]code.Opcode{
+	token.PLUS:  code.ADDS,
+	token.MINUS: code.SUBS,
+}
+
+var boolOps = map[token.TokenType]code.Opcode{
+	token.AND: code.AND,
+	token.OR:  code.OR,
+}
+
+// generic opcodes, dispatched on the runtime type of the operands
+var dynamicOps = map[token.TokenType]code.Opcode{
+	token.PLUS:  code.ADD,
+	token.MINUS: code.SUB,
+	token.MUL:   code.MUL,
+	token.DIV:   code.DIV,
+	token.IDIV:  code.IDIV,
+	token.MOD:   code.MOD,
+	token.LT:    code.LT,
+	token.GT:    code.GT,
+	token.LEQ:   code.LEQ,
+	token.GEQ:   code.GEQ,
+	token.EQ:    code.EQ,
+	token.NEQ:   code.NEQ,
+	token.AND:   code.AND,
+	token.OR:    code.OR,
+}
+
+// picks the opcode for a binary operator given the inferred operand types,
+// the checker has already rejected invalid combinations of known types.
+// Mixed numbers, and '/' between ints, are left to the VM which promotes
+// them.
+func binaryOp(op token.TokenType, left Type, right Type) code.Opcode {
+	if left == right {
+		var ops map[token.TokenType]code.Opcode
+		switch left {
+		case Int:
+			ops = intOps
+		case Decimal:
+			ops = decimalOps
+		case Float:
+			ops = floatOps
+		case String:
+			ops = stringOps
+		case Bool:
+			ops = boolOps
+		}
+		if typed, ok := ops[op]; ok {
+			return typed
+		}
+	}
+	return dynamicOps[op]
+}
+
+// emits a byte instruction
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	i := len(c.scope.co_code)
+	c.scope.co_code = append(c.scope.co_code, ins...)
+	c.addLine(i)
+
 	return i
 }
 
-// add a name (symbol) in co_names (global for now)
-func (c *Compiler) addName(name string) int {
-	// find the index
-	i := len(c.co_names)
-	for j := 0; j < len(c.co_names); j++ {
-		if c.co_names[j] == name {
-			i = j
-			break
+// sets the source position of the instructions emitted next
+func (c *Compiler) at(tok token.Token) {
+	c.scope.ln, c.scope.col = tok.Ln, tok.Col
+}
+
+// records the current position for the instruction at offset, the table
+// only grows when the position changes
+func (c *Compiler) addLine(offset int) {
+	s := c.scope
+	if s.ln == 0 {
+		return
+	}
+	if n := len(s.lines); n > 0 && s.lines[n-1].Ln == s.ln && s.lines[n-1].Col == s.col {
+		return
+	}
+	s.lines = append(s.lines, code.Position{Offset: offset, Ln: s.ln, Col: s.col})
+}
+
+// back-patch the jump at pos so it lands on the next instruction to be emitted
+func (c *Compiler) patchJump(pos int) {
+	ins := code.Make(c.scope.co_code[pos], len(c.scope.co_code))
+	copy(c.scope.co_code[pos:], ins)
+}
+
+// back-patch every jump in jumps so it lands on target
+func (c *Compiler) patchJumps(jumps []int, target int) {
+	for _, pos := range jumps {
+		ins := code.Make(c.scope.co_code[pos], target)
+		copy(c.scope.co_code[pos:], ins)
+	}
+}
+
+// emits the comparison between two variables: v1 op v2
+func (c *Compiler) emitCompare(v1 Symbol, op code.Opcode, v2 Symbol) {
+	c.loadVariable(v1)
+	c.loadVariable(v2)
+	c.emit(op)
+}
+
+func (c *Compiler) enterLoop() *loop {
+	l := &loop{depth: c.scope.symbols.Depth()}
+	c.scope.loops = append(c.scope.loops, l)
+	return l
+}
+
+func (c *Compiler) exitLoop() {
+	c.scope.loops = c.scope.loops[:len(c.scope.loops)-1]
+}
+
+// starts compiling a function body
+func (c *Compiler) enterFunction() {
+	c.scope = &compilationScope{
+		co_code:   []code.Opcode{},
+		symbols:   NewEnclosedSymbolTable(c.scope.symbols),
+		function:  true,
+		enclosing: c.scope,
+	}
+}
+
+// finishes the function body and returns its instructions
+func (c *Compiler) leaveFunction() []code.Opcode {
+	ins := c.scope.co_code
+	c.scope = c.scope.enclosing
+	return ins
+}
+
+func (c *Compiler) beginScope() {
+	c.scope.symbols.BeginScope()
+}
+
+// leaves the current scope discarding its locals from the stack
+func (c *Compiler) endScope() {
+	c.popLocals(c.scope.symbols.Depth() - 1)
+	c.scope.symbols.EndScope()
+}
+
+// discards every local declared deeper than depth, without forgetting
+// them (break/continue still need the scope bookkeeping). Captured locals
+// are moved off the stack into their upvalue instead of being dropped.
+func (c *Compiler) popLocals(depth int) {
+	for _, l := range c.scope.symbols.LocalsAbove(depth) {
+		if l.captured {
+			c.emit(code.CLOSE_UPVALUE)
+		} else {
+			c.emit(code.POP)
 		}
 	}
-	// add name(symbol)
-	c.co_names = append(c.co_names, name)
+}
 
+// declares a variable whose value is on top of the stack: top-level names
+// are stored as globals, names inside a block take over the stack slot
+func (c *Compiler) declareVariable(name token.Token) Symbol {
+	sym := c.defineSymbol(name)
+	if sym.Scope == GlobalScope {
+		c.emit(code.STORE, sym.Index)
+	}
+	return sym
+}
+
+func (c *Compiler) defineSymbol(name token.Token) Symbol {
+	sym, ok := c.scope.symbols.Define(name.Lexeme.(string))
+	if !ok {
+		c.addError(fmt.Sprintf("variable '%s' already declared in this scope at Ln: %d, Col: %d.", sym.Name, name.Ln, name.Col))
+	}
+	return sym
+}
+
+// resolves a name reporting undefined variables with their position
+func (c *Compiler) resolve(name token.Token) (Symbol, bool) {
+	sym, ok := c.scope.symbols.Resolve(name.Lexeme.(string))
+	if !ok {
+		c.addError(fmt.Sprintf("undefined variable '%s' at Ln: %d, Col: %d.", name.Lexeme, name.Ln, name.Col))
+	}
+	return sym, ok
+}
+
+func (c *Compiler) loadVariable(sym Symbol) {
+	switch sym.Scope {
+	case LocalScope:
+		c.emit(code.LOAD_LOCAL, sym.Index)
+	case UpvalueScope:
+		c.emit(code.LOAD_UPVALUE, sym.Index)
+	case BuiltinScope:
+		c.emit(code.LOAD_BUILTIN, sym.Index)
+	default:
+		c.emit(code.LOAD, sym.Index)
+	}
+}
+
+func (c *Compiler) storeVariable(sym Symbol) {
+	switch sym.Scope {
+	case LocalScope:
+		c.emit(code.STORE_LOCAL, sym.Index)
+	case UpvalueScope:
+		c.emit(code.STORE_UPVALUE, sym.Index)
+	default:
+		c.emit(code.STORE, sym.Index)
+	}
+}
+
+// adds a constant to the pool, one that is already there is reused
+func (c *Compiler) addConstant(cons object.Value) int {
+	key, ok := cons.Key()
+	if i, found := c.constants[key]; ok && found {
+		return i
+	}
+	var i int = len(c.co_consts)
+	c.co_consts = append(c.co_consts, cons)
+	if ok {
+		c.constants[key] = i
+	}
 	return i
 }
 
@@ -235,15 +710,3 @@ func (c *Compiler) addName(name string) int {
 func (c *Compiler) addError(msg string) {
 	c.errors = append(c.errors, msg)
 }
-
-func typeOf(op code.Opcode) byte {
-	if op == code.PUSHS || op == code.ADDS || op == code.SUBS {
-		return 's'
-	} else if op == code.PUSHF || op == code.ADD || op == code.SUB || op == code.MUL || op == code.DIV || op == code.LT ||
-		op == code.LEQ || op == code.GT || op == code.GEQ || op == code.EQ || op == code.NEQ || op == code.UNEG {
-		return 'f'
-	} else if op == code.TRUE || op == code.FALSE || op == code.AND || op == code.OR || op == code.NOT {
-		return 'l'
-	}
-	return 'u'
-}
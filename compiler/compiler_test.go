@@ -0,0 +1,321 @@
+package compiler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"vmlite/code"
+	"vmlite/lexer"
+	"vmlite/object"
+	"vmlite/parser"
+	"vmlite/vm"
+)
+
+// compiles and runs src, returning the value of each global. The parser
+// and compiler errors and the runtime error are returned
+func run(t *testing.T, src string) (map[string]object.Value, error) {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src))
+	program := p.Program()
+	if len(p.Errors()) > 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+	c := NewCompiler([]string{}, []object.Value{})
+	c.Compile(program)
+	if len(c.Errors()) > 0 {
+		return nil, errors.New(strings.Join(c.Errors(), "\n"))
+	}
+	values := make([]object.Value, 64)
+	machine, err := vm.NewVMFromBytecode(c.Bytecode(), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = machine.Run()
+	globals := map[string]object.Value{}
+	for i, name := range c.GetNames() {
+		globals[name] = values[i]
+	}
+	return globals, err
+}
+
+// runs src and checks the value of r, or the start of the error when want
+// starts with "error: "
+func expect(t *testing.T, src string, want string) {
+	t.Helper()
+	globals, err := run(t, src)
+	got := ""
+	if err != nil {
+		got = "error: " + err.Error()
+	} else {
+		got = globals["r"].String()
+	}
+	if got != want && !(strings.HasPrefix(want, "error: ") && strings.HasPrefix(got, want)) {
+		t.Errorf("%s: got %s, want %s", src, got, want)
+	}
+}
+
+func TestMutualRecursion(t *testing.T) {
+	globals, err := run(t, `
+func isEven(n)
+  if n == 0
+    return true
+  endif
+  return isOdd(n - 1)
+end
+func isOdd(n)
+  if n == 0
+    return false
+  endif
+  return isEven(n - 1)
+end
+var even = isEven(10)
+var odd = isOdd(7)
+var notOdd = isOdd(4)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"even", true},
+		{"odd", true},
+		{"notOdd", false},
+	}
+	for _, tt := range tests {
+		v := globals[tt.name]
+		if v.Kind != object.BoolKind || v.AsBool() != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, v, tt.want)
+		}
+	}
+}
+
+func TestIfElse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`var r = 0 if true r = 1 endif`, "1"},
+		{`var r = 0 if false r = 1 endif`, "0"},
+		{`var r = 0 if 1 > 2 r = 1 else r = 2 endif`, "2"},
+		{`var x = 1 var r = 0 if x < 3 r = 1 else if x < 6 r = 2 else r = 3 endif`, "1"},
+		{`var x = 5 var r = 0 if x < 3 r = 1 else if x < 6 r = 2 else r = 3 endif`, "2"},
+		{`var x = 9 var r = 0 if x < 3 r = 1 else if x < 6 r = 2 else r = 3 endif`, "3"},
+		{`var r = 0 if true if false r = 1 else r = 2 endif endif`, "2"},
+		{`var r = 0 if 1 r = 1 endif`, "error: condition must be a boolean, got int at Ln: 1, Col: 11."},
+		{`var x = 1 var r = 0 if x r = 1 endif`, "error: condition must be a boolean value at Ln: 1, Col: 21"},
+	}
+	for _, tt := range tests {
+		expect(t, tt.src, tt.want)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`var r = 0 var i = 0 while i < 5 r = r + i i += 1 endwhile`, "10"},
+		{`var r = 0 while false r = 1 endwhile`, "0"},
+		{`var r = 0 for i = 1 to 4 r = r + i endfor`, "10"},
+		{`var r = 0 for i = 1 to 10 step 3 r = r + i endfor`, "22"},
+		{`var r = 0 for i = 10 to 1 step -4 r = r * 100 + i endfor`, "100602"},
+		{`var r = 0 for i = 1 to 0 r = 99 endfor`, "0"},
+		{`var r = 0 for i = 1 to 10 if i > 3 break endif r = i endfor`, "3"},
+		{`var r = 0 for i = 1 to 10 if i > 3 exit endif r = i endfor`, "3"},
+		{`var r = 0 for i = 1 to 6 if i % 2 == 0 continue endif r = r + i endfor`, "9"},
+		{`var r = 0 for i = 1 to 6 if i % 2 == 0 loop endif r = r + i endfor`, "9"},
+		{`var r = 0 var i = 0 while i < 6 i += 1 if i % 2 == 0 continue endif r = r + i endwhile`, "9"},
+		// break leaves the inner loop only
+		{`var r = 0 for i = 1 to 3 for j = 1 to 3 if j == 2 break endif r = r + 1 endfor endfor`, "3"},
+		// the locals of the blocks left are dropped
+		{`var r = 0 while true do var a = 5 r = a break end endwhile var b = 1 r = r + b`, "6"},
+		{`func f() var s = 0 for i = 1 to 5 var k = i * 2 if k > 6 break endif s = s + k endfor return s end var r = f()`, "12"},
+		{`break`, "error: 'break' outside of a loop at Ln: 1, Col: 1."},
+		{`func f() continue end`, "error: 'continue' outside of a loop at Ln: 1, Col: 10."},
+		{"var r = 0\n  exit", "error: 'exit' outside of a loop at Ln: 2, Col: 3."},
+	}
+	for _, tt := range tests {
+		expect(t, tt.src, tt.want)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	counter := `
+func counter()
+  var n = 0
+  func inc()
+    n += 1
+    return n
+  end
+  return inc
+end
+`
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{counter + `var c = counter() c() var r = c()`, "2"},
+		// each call captures its own variable
+		{counter + `var a = counter() var b = counter() a() a() var r = a() * 10 + b()`, "31"},
+		// two closures share the variable they capture
+		{`
+var get = 0
+var set = 0
+func make()
+  var v = 1
+  func g() return v end
+  func s(x) v = x return 0 end
+  get = g
+  set = s
+  return 0
+end
+make()
+set(7)
+var r = get()`, "7"},
+		// an enclosing function's upvalue is captured through its closure
+		{`
+func outer()
+  var x = 1
+  func middle()
+    func inner()
+      x = x + 10
+      return x
+    end
+    return inner
+  end
+  return middle
+end
+var r = outer()()()`, "11"},
+		// a block local is closed when the block ends
+		{`
+var f = 0
+do
+  var v = 3
+  func g() return v end
+  f = g
+end
+var r = f()`, "3"},
+		// and when a loop is left with break
+		{`
+var f = 0
+for i = 1 to 5
+  var k = i * 10
+  func g() return k end
+  f = g
+  if i == 2
+    break
+  endif
+endfor
+var r = f()`, "20"},
+		// each iteration captures a fresh variable
+		{`
+var first = 0
+var last = 0
+for i = 1 to 3
+  var k = i
+  func g() return k end
+  if i == 1
+    first = g
+  endif
+  last = g
+endfor
+var r = first() * 10 + last()`, "13"},
+	}
+	for _, tt := range tests {
+		expect(t, tt.src, tt.want)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`var r = 1 r = 5`, "5"},
+		{`var r = 10 r += 5`, "15"},
+		{`var r = 10 r -= 15`, "-5"},
+		{`var r = 3 r *= 4`, "12"},
+		{`var r = 1 r /= 4`, "0.25"},
+		{`var r = 1 r *= 2.5`, "2.5"},
+		{`var r = "a" r += "b"`, "ab"},
+		// an assignment is an expression, its value is the one assigned
+		{`var a = 0 var b = 0 a = b = 2 var r = a + b`, "4"},
+		{`var x = 1 var r = (x = 3) + x`, "6"},
+		{`func f(n) n += 1 n *= 10 return n end var r = f(1)`, "20"},
+		{`var r = 0 do var l = 2 l -= 5 r = l end`, "-3"},
+		{`func f() var n = 1 func g() n *= 3 return n end g() return g() end var r = f()`, "9"},
+		{`var r = 1 r + 1 = 3`, "error: Ln 1, Col: 17 -> <ASSIGN, '='> invalid assignment target."},
+		{`y = 1`, "error: undefined variable 'y' at Ln: 1, Col: 1."},
+		{`var r = 1 r += "a"`, "error: unsupported operand types for ADD: int and string at Ln: 1, Col: 13"},
+	}
+	for _, tt := range tests {
+		expect(t, tt.src, tt.want)
+	}
+}
+
+// globals have no static type, their operators are dispatched at run time
+func TestGenericOperators(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`var a = "x" var r = a == "x"`, "true"},
+		{`var a = "x" var r = a != "y"`, "true"},
+		{`var a = "a" var r = a < "b"`, "true"},
+		{`var a = "a" var r = a + "b"`, "ab"},
+		{`var a = "ab " var r = a - "c"`, "abc"},
+		{`var a = true var r = a == true`, "true"},
+		{`var a = true var r = a != false`, "true"},
+		{`var a = 1 var r = a == "1"`, "false"},
+		{`var a = 1 var r = a < 2.5`, "true"},
+		{`var a = $1 var r = a == 1`, "true"},
+		{`var a = 2 var r = a * 1.5`, "3"},
+		{`var a = true var r = a + 1`, "error: unsupported operand types for ADD: bool and int at Ln: 1, Col: 24"},
+		{`var a = "a" var r = a < 1`, "error: unsupported operand types for LT: string and int at Ln: 1, Col: 23"},
+		{`var a = 1 var r = a and true`, "error: unsupported operand types for AND: int and bool at Ln: 1, Col: 21"},
+		{`var a = "a" var r = -a`, "error: unsupported operand type for NEG: string at Ln: 1, Col: 21"},
+		{`var a = "a" var r = !a`, "error: unsupported operand type for NOT: string at Ln: 1, Col: 21"},
+	}
+	for _, tt := range tests {
+		expect(t, tt.src, tt.want)
+	}
+
+	_, err := run(t, `var a = true var r = a * "s"`)
+	var typeErr *vm.TypeError
+	if !errors.As(err, &typeErr) || typeErr.Op != code.MUL || len(typeErr.Operands) != 2 {
+		t.Errorf("got %#v, want a *vm.TypeError of MUL with both operands", err)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// floored: the quotient rounds down, the remainder has the sign of
+		// the divisor, for typed locals and for globals alike
+		{`var r = 0 do var a = -7 var b = 2 r = a // b end`, "-4"},
+		{`var r = 0 do var a = 7 var b = -2 r = a % b end`, "-1"},
+		{`var r = 0 do var a = -7.5 var b = 2.0 r = a // b end`, "-4"},
+		{`var r = 0 do var a = 7.5 var b = -2.0 r = a % b end`, "-0.5"},
+		{`var a = -7 var r = a % 3`, "2"},
+		{`var a = -7.0 var r = a // 2`, "-4"},
+		// '/' is always a float division, an int meets a float as a float
+		{`var r = 0 do var a = 1 r = a / 2 end`, "0.5"},
+		{`var r = 0 do var a = 2 var f = 0.5 r = a + f end`, "2.5"},
+		// ints keep their precision past 2^53
+		{`var r = 9007199254740993`, "9007199254740993"},
+		{`var r = 0 do var a = 9223372036854775807 r = a + 1 end`, "error: integer overflow at Ln: 1, Col: 48"},
+		{`var r = 0 do var a = 3037000500 r = a * a end`, "error: integer overflow at Ln: 1, Col: 39"},
+		{`var r = 0 do var a = -9223372036854775807 - 1 r = a // -1 end`, "error: integer overflow at Ln: 1, Col: 53"},
+		{`var r = 0 do var a = -9223372036854775807 - 1 r = -a end`, "error: integer overflow at Ln: 1, Col: 51"},
+		{`var a = 9223372036854775807 var r = a + 1`, "error: integer overflow at Ln: 1, Col: 39"},
+		{`var r = 0 do var a = 0 r = 1 % a end`, "error: division by zero at Ln: 1, Col: 30"},
+		{`var r = 0 do var a = 0.0 r = 1.0 // a end`, "error: division by zero at Ln: 1, Col: 34"},
+	}
+	for _, tt := range tests {
+		expect(t, tt.src, tt.want)
+	}
+}
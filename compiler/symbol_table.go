@@ -0,0 +1,167 @@
+package compiler
+
+import "vmlite/object"
+
+// SymbolScope tells where a resolved name lives at run time
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"  // slot in co_names/co_values
+	LocalScope   SymbolScope = "LOCAL"   // stack slot relative to the frame
+	UpvalueScope SymbolScope = "UPVALUE" // variable captured from an enclosing function
+	BuiltinScope SymbolScope = "BUILTIN" // function provided by the VM
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// a block-scoped variable living in a stack slot
+type local struct {
+	name     string
+	depth    int
+	captured bool // referenced by a closure, must be closed when it goes out of scope
+}
+
+// SymbolTable keeps the names visible while compiling one function. The
+// outermost table belongs to the top-level code and also owns the globals.
+type SymbolTable struct {
+	Outer    *SymbolTable
+	names    []string // globals, only used by the outermost table
+	locals   []local
+	upvalues []object.UpvalueInfo // enclosing variables captured by the function
+	depth    int                  // current block nesting, 0 means global scope
+}
+
+func NewSymbolTable(names []string) *SymbolTable {
+	return &SymbolTable{names: names}
+}
+
+// a function body: parameters and locals are stack slots relative to the
+// frame, so the body starts one level deep
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{Outer: outer, depth: 1}
+}
+
+func (s *SymbolTable) Names() []string {
+	return s.names
+}
+
+func (s *SymbolTable) Upvalues() []object.UpvalueInfo {
+	return s.upvalues
+}
+
+func (s *SymbolTable) Depth() int {
+	return s.depth
+}
+
+func (s *SymbolTable) BeginScope() {
+	s.depth += 1
+}
+
+// leaves the current block forgetting its locals, the caller must have
+// discarded them from the stack (see LocalsAbove)
+func (s *SymbolTable) EndScope() {
+	s.depth -= 1
+	for len(s.locals) > 0 && s.locals[len(s.locals)-1].depth > s.depth {
+		s.locals = s.locals[:len(s.locals)-1]
+	}
+}
+
+// returns the locals declared deeper than depth, innermost first
+func (s *SymbolTable) LocalsAbove(depth int) []local {
+	found := []local{}
+	for i := len(s.locals) - 1; i >= 0 && s.locals[i].depth > depth; i-- {
+		found = append(found, s.locals[i])
+	}
+	return found
+}
+
+// Define declares name in the current block: top-level names are globals
+// (reusing the slot of a previous declaration), everything else is a local.
+// It reports false when a local with that name already exists in the block.
+func (s *SymbolTable) Define(name string) (Symbol, bool) {
+	if s.Outer == nil && s.depth == 0 {
+		for i, n := range s.names {
+			if n == name {
+				return Symbol{Name: name, Scope: GlobalScope, Index: i}, true
+			}
+		}
+		s.names = append(s.names, name)
+		return Symbol{Name: name, Scope: GlobalScope, Index: len(s.names) - 1}, true
+	}
+	for i := len(s.locals) - 1; i >= 0 && s.locals[i].depth == s.depth; i-- {
+		if s.locals[i].name == name {
+			return Symbol{Name: name, Scope: LocalScope, Index: i}, false
+		}
+	}
+	return s.DefineLocal(name), true
+}
+
+// DefineLocal declares a stack slot local even at the top level
+func (s *SymbolTable) DefineLocal(name string) Symbol {
+	s.locals = append(s.locals, local{name: name, depth: s.depth})
+	return Symbol{Name: name, Scope: LocalScope, Index: len(s.locals) - 1}
+}
+
+// Resolve looks a name up in the enclosing blocks first, then in the
+// enclosing functions (capturing it as an upvalue), then in the globals
+// and finally in the builtins.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	if i := s.resolveLocal(name); i >= 0 {
+		return Symbol{Name: name, Scope: LocalScope, Index: i}, true
+	}
+	if i := s.resolveUpvalue(name); i >= 0 {
+		return Symbol{Name: name, Scope: UpvalueScope, Index: i}, true
+	}
+	global := s
+	for global.Outer != nil {
+		global = global.Outer
+	}
+	for i, n := range global.names {
+		if n == name {
+			return Symbol{Name: name, Scope: GlobalScope, Index: i}, true
+		}
+	}
+	if i, ok := object.LookupBuiltin(name); ok {
+		return Symbol{Name: name, Scope: BuiltinScope, Index: i}, true
+	}
+	return Symbol{}, false
+}
+
+func (s *SymbolTable) resolveLocal(name string) int {
+	for i := len(s.locals) - 1; i >= 0; i-- {
+		if s.locals[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolves name as a local of an enclosing function, threading the upvalue
+// through every function in between
+func (s *SymbolTable) resolveUpvalue(name string) int {
+	if s.Outer == nil {
+		return -1
+	}
+	if i := s.Outer.resolveLocal(name); i >= 0 {
+		s.Outer.locals[i].captured = true
+		return s.addUpvalue(i, true)
+	}
+	if i := s.Outer.resolveUpvalue(name); i >= 0 {
+		return s.addUpvalue(i, false)
+	}
+	return -1
+}
+
+func (s *SymbolTable) addUpvalue(index int, isLocal bool) int {
+	for i, u := range s.upvalues {
+		if u.Index == index && u.IsLocal == isLocal {
+			return i
+		}
+	}
+	s.upvalues = append(s.upvalues, object.UpvalueInfo{IsLocal: isLocal, Index: index})
+	return len(s.upvalues) - 1
+}
@@ -1,33 +1,44 @@
 package lexer
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"unicode"
+	"vmlite/object"
 	"vmlite/token"
 )
 
 var EOF_CHAR = rune(0)
 
+var errDecimalPlaces = fmt.Errorf("too many decimal places")
+
 type Lexer struct {
-	pos   int
-	c     rune
-	ln    int
-	col   int
-	input []rune
+	pos    int
+	c      rune
+	ln     int
+	col    int
+	input  []rune
+	errors []string
 }
 
 func NewLexer(input string) *Lexer {
 	l := &Lexer{
-		pos:   -1,
-		ln:    1,
-		col:   0,
-		input: []rune(input),
+		pos:    -1,
+		ln:     1,
+		col:    0,
+		input:  []rune(input),
+		errors: []string{},
 	}
 	l.consume() // prime first char
 	return l
 }
 
+func (l *Lexer) Errors() []string {
+	return l.errors
+}
+
 func (l *Lexer) consume() {
 	l.pos += 1
 	if l.pos >= len(l.input) {
@@ -49,19 +60,93 @@ func (l *Lexer) ws() {
 	}
 }
 
+// reads integer (1_000, 0xff, 0b101), float (3.14, .5, 1e6) and decimal
+// ($12.3456, 12.50m) literals, malformed ones are reported and read as 0
 func (l *Lexer) getNum() token.Token {
-	pos := l.pos
+	start := l.pos
 	ln := l.ln
 	col := l.col
-	for !l.isAtEnd() && unicode.IsNumber(l.c) {
+	isDecimal := l.c == '$'
+	if isDecimal {
 		l.consume()
 	}
-	lex := string(l.input[pos:l.pos])
-	v, ok := strconv.ParseFloat(lex, 32)
-	if ok != nil {
-		panic(ok)
+	pos := l.pos
+	prefixed := l.c == '0' && (l.peek() == 'x' || l.peek() == 'X' || l.peek() == 'b' || l.peek() == 'B')
+	isFloat := false
+	if prefixed && !isDecimal {
+		l.consume()
+		l.consume()
+	} else {
+		prefixed = false
+		l.digits()
+		if l.c == '.' {
+			isFloat = true
+			l.consume()
+			l.digits()
+		}
+		if l.c == 'e' || l.c == 'E' {
+			isFloat = true
+			l.consume()
+			if l.c == '+' || l.c == '-' {
+				l.consume()
+			}
+			l.digits()
+		}
+	}
+	end := l.pos
+	if !isDecimal && !prefixed && l.c == 'm' && !l.isIdent(l.peek()) {
+		isDecimal = true
+		l.consume()
+	}
+	// anything glued to the literal makes it malformed: 12abc, 1.2.3, 0x1g
+	for !l.isAtEnd() && (l.isIdent(l.c) || unicode.IsDigit(l.c) || l.c == '.') {
+		l.consume()
+	}
+
+	lex := string(l.input[start:l.pos])
+	var v interface{}
+	var err error
+	if isDecimal {
+		v, err = l.decimal(lex, string(l.input[pos:end]))
+	} else if prefixed {
+		v, err = strconv.ParseInt(lex, 0, 64) // also checks the '_' separators
+	} else if isFloat {
+		v, err = strconv.ParseFloat(lex, 64)
+	} else if _, err = strconv.ParseFloat(lex, 64); err == nil {
+		// base 10, a leading 0 is not octal
+		v, err = strconv.ParseInt(strings.ReplaceAll(lex, "_", ""), 10, 64)
+	}
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) || errors.Is(err, object.ErrDecimalOverflow) {
+			l.addError(fmt.Sprintf("number literal '%s' out of range at Ln: %d, Col: %d", lex, ln, col))
+		} else if errors.Is(err, errDecimalPlaces) {
+			l.addError(fmt.Sprintf("decimal literal '%s' has more than %d decimal places at Ln: %d, Col: %d", lex, object.DECIMAL_PLACES, ln, col))
+		} else {
+			l.addError(fmt.Sprintf("malformed number literal '%s' at Ln: %d, Col: %d", lex, ln, col))
+		}
+		v = int64(0)
+	}
+	return token.NewToken(ln, col, token.NUMBER, v)
+}
+
+// decimals are written with at most 4 places and no exponent
+func (l *Lexer) decimal(lex string, digits string) (object.Decimal, error) {
+	if lex != "$"+digits && lex != digits+"m" || strings.ContainsAny(lex, "eE") {
+		return 0, fmt.Errorf("malformed decimal")
+	}
+	if _, frac, ok := strings.Cut(digits, "."); ok && len(frac) > object.DECIMAL_PLACES {
+		return 0, errDecimalPlaces
+	}
+	if _, err := strconv.ParseFloat(digits, 64); err != nil {
+		return 0, err // checks the '_' separators
+	}
+	return object.ParseDecimal(strings.ReplaceAll(digits, "_", ""), object.RoundHalfUp)
+}
+
+func (l *Lexer) digits() {
+	for !l.isAtEnd() && (unicode.IsDigit(l.c) || l.c == '_') {
+		l.consume()
 	}
-	return token.NewToken(ln, col, token.NUMBER, float32(v))
 }
 
 func (l *Lexer) getString() token.Token {
@@ -73,7 +158,8 @@ func (l *Lexer) getString() token.Token {
 	for {
 		l.consume()
 		if l.isAtEnd() {
-			panic(fmt.Sprintf("untermitated string at Ln: %d, Col: %d", ln, col))
+			l.addError(fmt.Sprintf("unterminated string at Ln: %d, Col: %d", ln, col))
+			return token.NewToken(ln, col, token.STRING, string(l.input[pos:]))
 		}
 		if l.c == s {
 			break
@@ -102,7 +188,7 @@ func (l *Lexer) NextToken() token.Token {
 			l.ws()
 			continue
 		}
-		if unicode.IsNumber(l.c) {
+		if unicode.IsDigit(l.c) || l.c == '$' || (l.c == '.' && unicode.IsDigit(l.peek())) {
 			return l.getNum()
 		}
 		if l.c == '"' || l.c == '\'' {
@@ -123,11 +209,23 @@ func (l *Lexer) NextToken() token.Token {
 			}
 			return token.NewToken(ln, col, tok, s1)
 		}
-		panic(fmt.Sprintf("unknown character '%c' at Ln: %d, Col: %d", l.c, l.ln, l.col))
+		l.addError(fmt.Sprintf("unknown character '%c' at Ln: %d, Col: %d", l.c, l.ln, l.col))
+		l.consume()
 	}
 	return token.NewToken(l.ln, l.col, token.EOF, "")
 }
 
+func (l *Lexer) peek() rune {
+	if l.pos+1 >= len(l.input) {
+		return EOF_CHAR
+	}
+	return l.input[l.pos+1]
+}
+
+func (l *Lexer) addError(msg string) {
+	l.errors = append(l.errors, msg)
+}
+
 func (l *Lexer) isAtEnd() bool {
 	return l.c == EOF_CHAR
 }
@@ -0,0 +1,86 @@
+package lexer
+
+import (
+	"testing"
+	"vmlite/object"
+	"vmlite/token"
+)
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"42", int64(42)},
+		{"07", int64(7)}, // not octal
+		{"1_000_000", int64(1000000)},
+		{"0x1F", int64(31)},
+		{"0XfF", int64(255)},
+		{"0x_ff", int64(255)},
+		{"0b101", int64(5)},
+		{"0B1_0", int64(2)},
+		{"3.14", 3.14},
+		{".5", 0.5},
+		{"1e6", 1e6},
+		{"1.5e-3", 0.0015},
+		{"1_000.5", 1000.5},
+		{"$12.3456", object.Decimal(123456)},
+		{"$1_000.5", object.Decimal(10005000)},
+		{"$7", object.Decimal(70000)},
+		{"12.50m", object.Decimal(125000)},
+		{"3m", object.Decimal(30000)},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.src)
+		tok := l.NextToken()
+		if len(l.Errors()) > 0 {
+			t.Errorf("%s: %v", tt.src, l.Errors())
+		}
+		if tok.Type != token.NUMBER || tok.Lexeme != tt.want {
+			t.Errorf("%s: got %s, want %T %v", tt.src, tok.ToString(), tt.want, tt.want)
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("%s: the literal ends before %s", tt.src, tok.ToString())
+		}
+	}
+}
+
+// a malformed literal is reported and read as a 0
+func TestNumberErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"$1.23456", "decimal literal '$1.23456' has more than 4 decimal places at Ln: 1, Col: 1"},
+		{"1.23456m", "decimal literal '1.23456m' has more than 4 decimal places at Ln: 1, Col: 1"},
+		{"99999999999999999999", "number literal '99999999999999999999' out of range at Ln: 1, Col: 1"},
+		{"0x1_0000_0000_0000_0000", "number literal '0x1_0000_0000_0000_0000' out of range at Ln: 1, Col: 1"},
+		{"$99999999999999999", "number literal '$99999999999999999' out of range at Ln: 1, Col: 1"},
+		{"12abc", "malformed number literal '12abc' at Ln: 1, Col: 1"},
+		{"1.2.3", "malformed number literal '1.2.3' at Ln: 1, Col: 1"},
+		{"1e", "malformed number literal '1e' at Ln: 1, Col: 1"},
+		{"1__0", "malformed number literal '1__0' at Ln: 1, Col: 1"},
+		{"1_", "malformed number literal '1_' at Ln: 1, Col: 1"},
+		{"0x", "malformed number literal '0x' at Ln: 1, Col: 1"},
+		{"0xg", "malformed number literal '0xg' at Ln: 1, Col: 1"},
+		{"0b102", "malformed number literal '0b102' at Ln: 1, Col: 1"},
+		{"$", "malformed number literal '$' at Ln: 1, Col: 1"},
+		{"$0x10", "malformed number literal '$0x10' at Ln: 1, Col: 1"},
+		{"$1e3", "malformed number literal '$1e3' at Ln: 1, Col: 1"},
+		{"1e3m", "malformed number literal '1e3m' at Ln: 1, Col: 1"},
+		{"$1m", "malformed number literal '$1m' at Ln: 1, Col: 1"},
+		{"x = \n  0x1g", "malformed number literal '0x1g' at Ln: 2, Col: 3"},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.src)
+		var tok token.Token
+		for tok = l.NextToken(); tok.Type != token.EOF && tok.Type != token.NUMBER; tok = l.NextToken() {
+		}
+		if len(l.Errors()) != 1 || l.Errors()[0] != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.src, l.Errors(), tt.want)
+		}
+		if tok.Type != token.NUMBER || tok.Lexeme != int64(0) {
+			t.Errorf("%s: got %s, want a 0", tt.src, tok.ToString())
+		}
+	}
+}
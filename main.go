@@ -1,9 +1,34 @@
 package main
 
-import "vmlite/repl"
+import (
+	"fmt"
+	"os"
+	"vmlite/optimizer"
+	"vmlite/repl"
+)
 
+// usage: vmlite [-O0|-O1|-O2] [repl|lexer|parser|compiler|vm|asm] [file]
 func main() {
 	mode := "repl"
 	input := `print 1 + 2`
+	args := []string{}
+	for _, arg := range os.Args[1:] {
+		if level, ok := optimizer.ParseLevel(arg); ok {
+			repl.SetOptLevel(level)
+		} else {
+			args = append(args, arg)
+		}
+	}
+	if len(args) > 0 {
+		mode = args[0]
+	}
+	if len(args) > 1 {
+		src, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		input = string(src)
+	}
 	repl.Start(mode, input)
 }
@@ -0,0 +1,224 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type BuiltinFunction func(args ...Value) (Value, error)
+
+// Builtin is a function implemented by the VM itself
+type Builtin struct {
+	Name string
+	Fn   BuiltinFunction
+}
+
+func (b *Builtin) String() string {
+	return fmt.Sprintf("<builtin %s>", b.Name)
+}
+
+// Builtins are resolved after the globals, so user names may shadow them.
+// The position of each entry is its LOAD_BUILTIN operand: only append.
+var Builtins = []*Builtin{
+	{Name: "len", Fn: builtinLen},
+	{Name: "str", Fn: builtinStr},
+	{Name: "val", Fn: builtinVal},
+	{Name: "type", Fn: builtinType},
+	{Name: "decimal", Fn: builtinDecimal},
+	{Name: "float", Fn: builtinFloat},
+	{Name: "round", Fn: builtinRound},
+	{Name: "setround", Fn: builtinSetRound},
+}
+
+func LookupBuiltin(name string) (int, bool) {
+	for i, b := range Builtins {
+		if b.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// len(s) returns the number of characters of a string
+func builtinLen(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Nil, fmt.Errorf("len() expects 1 argument, got %d", len(args))
+	}
+	if args[0].Kind != StringKind {
+		return Nil, fmt.Errorf("len() expects a string, got %s", TypeName(args[0]))
+	}
+	return IntValue(int64(len([]rune(args[0].AsString())))), nil
+}
+
+// str(x) converts any value into its string representation
+func builtinStr(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Nil, fmt.Errorf("str() expects 1 argument, got %d", len(args))
+	}
+	if args[0].Kind == FloatKind {
+		return StringValue(strconv.FormatFloat(args[0].AsFloat(), 'f', -1, 64)), nil
+	}
+	return StringValue(args[0].String()), nil
+}
+
+// val(s) converts a string into a number, like FoxPro an invalid number is 0
+func builtinVal(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Nil, fmt.Errorf("val() expects 1 argument, got %d", len(args))
+	}
+	if args[0].Kind != StringKind {
+		return Nil, fmt.Errorf("val() expects a string, got %s", TypeName(args[0]))
+	}
+	s := strings.TrimSpace(args[0].AsString())
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return IntValue(i), nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return IntValue(0), nil
+	}
+	return FloatValue(v), nil
+}
+
+// type(x) returns the type name of a value
+func builtinType(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Nil, fmt.Errorf("type() expects 1 argument, got %d", len(args))
+	}
+	return StringValue(TypeName(args[0])), nil
+}
+
+// decimal(x [, mode]) converts an int, a float or a string into a decimal,
+// extra decimals are rounded with mode or the current rounding mode
+func builtinDecimal(args ...Value) (Value, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return Nil, fmt.Errorf("decimal() expects 1 or 2 arguments, got %d", len(args))
+	}
+	mode := DecimalRounding
+	if len(args) == 2 {
+		m, err := roundingArg("decimal", args[1])
+		if err != nil {
+			return Nil, err
+		}
+		mode = m
+	}
+	var d Decimal
+	var err error
+	switch v := args[0]; v.Kind {
+	case DecimalKind:
+		d = v.AsDecimal()
+	case IntKind:
+		d, err = DecimalFromInt(v.AsInt())
+	case FloatKind:
+		d, err = DecimalFromFloat(v.AsFloat(), mode)
+	case StringKind:
+		d, err = ParseDecimal(strings.TrimSpace(v.AsString()), mode)
+	default:
+		return Nil, fmt.Errorf("decimal() cannot convert a %s", TypeName(args[0]))
+	}
+	if err != nil {
+		return Nil, err
+	}
+	return DecimalValue(d), nil
+}
+
+// float(x) converts an int, a decimal or a string into a float
+func builtinFloat(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Nil, fmt.Errorf("float() expects 1 argument, got %d", len(args))
+	}
+	switch v := args[0]; v.Kind {
+	case FloatKind:
+		return v, nil
+	case IntKind:
+		return FloatValue(float64(v.AsInt())), nil
+	case DecimalKind:
+		return FloatValue(v.AsDecimal().Float()), nil
+	case StringKind:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.AsString()), 64)
+		if err != nil {
+			return Nil, fmt.Errorf("float() invalid number '%s'", v.AsString())
+		}
+		return FloatValue(f), nil
+	}
+	return Nil, fmt.Errorf("float() cannot convert a %s", TypeName(args[0]))
+}
+
+// round(x [, places [, mode]]) rounds a decimal or a float, by default to
+// no decimals with the current rounding mode
+func builtinRound(args ...Value) (Value, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return Nil, fmt.Errorf("round() expects 1 to 3 arguments, got %d", len(args))
+	}
+	places := int64(0)
+	if len(args) > 1 {
+		if args[1].Kind != IntKind {
+			return Nil, fmt.Errorf("round() expects an int number of places, got %s", TypeName(args[1]))
+		}
+		places = args[1].AsInt()
+	}
+	mode := DecimalRounding
+	if len(args) > 2 {
+		m, err := roundingArg("round", args[2])
+		if err != nil {
+			return Nil, err
+		}
+		mode = m
+	}
+	switch v := args[0]; v.Kind {
+	case IntKind:
+		return v, nil
+	case DecimalKind:
+		d, err := v.AsDecimal().Round(int(places), mode)
+		if err != nil {
+			return Nil, err
+		}
+		return DecimalValue(d), nil
+	case FloatKind:
+		if places < 0 || places > 15 {
+			return Nil, fmt.Errorf("a float can be rounded to 0-15 places, got %d", places)
+		}
+		s := strconv.FormatFloat(v.AsFloat(), 'f', -1, 64)
+		r, err := roundDigits(s, int(places), mode)
+		if err != nil {
+			return Nil, err
+		}
+		f, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return Nil, err
+		}
+		return FloatValue(f), nil
+	}
+	return Nil, fmt.Errorf("round() expects a number, got %s", TypeName(args[0]))
+}
+
+// setround(mode) changes how decimal products and quotients are rounded and
+// returns the previous mode
+func builtinSetRound(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return Nil, fmt.Errorf("setround() expects 1 argument, got %d", len(args))
+	}
+	m, err := roundingArg("setround", args[0])
+	if err != nil {
+		return Nil, err
+	}
+	prev := DecimalRounding
+	DecimalRounding = m
+	return StringValue(prev.String()), nil
+}
+
+func roundingArg(fn string, arg Value) (RoundingMode, error) {
+	if arg.Kind != StringKind {
+		return RoundHalfUp, fmt.Errorf("%s() expects a rounding mode name, got %s", fn, TypeName(arg))
+	}
+	m, ok := LookupRoundingMode(arg.AsString())
+	if !ok {
+		return RoundHalfUp, fmt.Errorf("%s() unknown rounding mode '%s', expected one of %s", fn, arg.AsString(), strings.Join(roundingNames, ", "))
+	}
+	return m, nil
+}
+
+func TypeName(v Value) string {
+	return v.Kind.String()
+}
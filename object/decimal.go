@@ -0,0 +1,280 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact number with 4 fixed decimals, like FoxPro's Currency.
+// It is stored scaled: 12.3456 is Decimal(123456).
+type Decimal int64
+
+const DECIMAL_PLACES = 4
+const DECIMAL_SCALE = 10000
+
+type RoundingMode byte
+
+const (
+	RoundHalfUp   RoundingMode = iota // half away from zero, as FoxPro does
+	RoundHalfEven                     // banker's rounding
+	RoundHalfDown                     // half towards zero
+	RoundUp                           // away from zero
+	RoundDown                         // towards zero, truncates
+	RoundCeiling
+	RoundFloor
+)
+
+var roundingNames = []string{"half_up", "half_even", "half_down", "up", "down", "ceiling", "floor"}
+
+func (m RoundingMode) String() string {
+	return roundingNames[m]
+}
+
+func LookupRoundingMode(name string) (RoundingMode, bool) {
+	for i, n := range roundingNames {
+		if n == name {
+			return RoundingMode(i), true
+		}
+	}
+	return RoundHalfUp, false
+}
+
+// DecimalRounding is used when a product or a quotient has more than 4
+// decimals, it is changed with the setround() builtin
+var DecimalRounding = RoundHalfUp
+
+var ErrDecimalOverflow = fmt.Errorf("decimal overflow")
+
+func (d Decimal) String() string {
+	u, neg := abs(int64(d))
+	s := fmt.Sprintf("%d.%04d", u/DECIMAL_SCALE, u%DECIMAL_SCALE)
+	if neg {
+		return "-" + s
+	}
+	return s
+}
+
+func (d Decimal) Float() float64 {
+	return float64(d) / DECIMAL_SCALE
+}
+
+func DecimalFromInt(i int64) (Decimal, error) {
+	if i > math.MaxInt64/DECIMAL_SCALE || i < math.MinInt64/DECIMAL_SCALE {
+		return 0, ErrDecimalOverflow
+	}
+	return Decimal(i * DECIMAL_SCALE), nil
+}
+
+// DecimalFromFloat rounds the shortest representation of f, so 1.15 is
+// 1.15 and not 1.149999...
+func DecimalFromFloat(f float64, mode RoundingMode) (Decimal, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("cannot convert %v to decimal", f)
+	}
+	return ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64), mode)
+}
+
+// ParseDecimal reads [-]digits[.digits], extra decimals are rounded with mode
+func ParseDecimal(s string, mode RoundingMode) (Decimal, error) {
+	r, err := roundDigits(s, DECIMAL_PLACES, mode)
+	if err != nil {
+		return 0, err
+	}
+	neg := strings.HasPrefix(r, "-")
+	intPart, frac, _ := strings.Cut(strings.TrimPrefix(r, "-"), ".")
+	frac += strings.Repeat("0", DECIMAL_PLACES-len(frac))
+	u, err := strconv.ParseUint(intPart+frac, 10, 64)
+	if err != nil {
+		return 0, ErrDecimalOverflow
+	}
+	return signed(u, neg)
+}
+
+// rounds the number written in s to the given decimal places, working on
+// the digits so nothing is lost on the way
+func roundDigits(s string, places int, mode RoundingMode) (string, error) {
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		if s[0] == '-' {
+			sign = "-"
+		}
+		s = s[1:]
+	}
+	intPart, frac, _ := strings.Cut(s, ".")
+	if (intPart == "" && frac == "") || !isDigits(intPart) || !isDigits(frac) {
+		return "", fmt.Errorf("invalid decimal '%s'", s)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	rest := ""
+	if len(frac) > places {
+		frac, rest = frac[:places], frac[places:]
+	}
+
+	// compare the dropped digits with one half: 5000...
+	half := -1
+	if rest != "" && rest[0] >= '5' {
+		half = 0
+		if rest[0] > '5' || strings.Trim(rest[1:], "0") != "" {
+			half = 1
+		}
+	}
+	nonzero := strings.Trim(rest, "0") != ""
+	digits := intPart + frac
+	odd := (digits[len(digits)-1]-'0')%2 == 1
+	if roundAway(mode, sign == "-", nonzero, half, odd) {
+		digits = increment(digits)
+	}
+
+	n := len(digits) - len(frac)
+	r := digits[:n]
+	if frac != "" {
+		r += "." + digits[n:]
+	}
+	return sign + r, nil
+}
+
+// adds one to a string of digits
+func increment(digits string) string {
+	b := []byte(digits)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '9' {
+			b[i] += 1
+			return string(b)
+		}
+		b[i] = '0'
+	}
+	return "1" + string(b)
+}
+
+func (d Decimal) Add(o Decimal) (Decimal, error) {
+	v := d + o
+	if (o > 0 && v < d) || (o < 0 && v > d) {
+		return 0, ErrDecimalOverflow
+	}
+	return v, nil
+}
+
+func (d Decimal) Sub(o Decimal) (Decimal, error) {
+	v := d - o
+	if (o > 0 && v > d) || (o < 0 && v < d) {
+		return 0, ErrDecimalOverflow
+	}
+	return v, nil
+}
+
+// Mul computes the exact product with 128 bits before rounding it back to
+// 4 decimals
+func (d Decimal) Mul(o Decimal, mode RoundingMode) (Decimal, error) {
+	a, na := abs(int64(d))
+	b, nb := abs(int64(o))
+	hi, lo := bits.Mul64(a, b)
+	if hi >= DECIMAL_SCALE {
+		return 0, ErrDecimalOverflow
+	}
+	q, rem := bits.Div64(hi, lo, DECIMAL_SCALE)
+	return roundQuotient(q, rem, DECIMAL_SCALE, na != nb, mode)
+}
+
+func (d Decimal) Div(o Decimal, mode RoundingMode) (Decimal, error) {
+	if o == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	a, na := abs(int64(d))
+	b, nb := abs(int64(o))
+	hi, lo := bits.Mul64(a, DECIMAL_SCALE)
+	if hi >= b {
+		return 0, ErrDecimalOverflow
+	}
+	q, rem := bits.Div64(hi, lo, b)
+	return roundQuotient(q, rem, b, na != nb, mode)
+}
+
+// Round keeps the given number of decimals (0 to 4)
+func (d Decimal) Round(places int, mode RoundingMode) (Decimal, error) {
+	if places < 0 || places > DECIMAL_PLACES {
+		return 0, fmt.Errorf("a decimal can be rounded to 0-%d places, got %d", DECIMAL_PLACES, places)
+	}
+	div := uint64(math.Pow10(DECIMAL_PLACES - places))
+	u, neg := abs(int64(d))
+	v, err := roundQuotient(u/div, u%div, div, neg, mode)
+	if err != nil {
+		return 0, err
+	}
+	return v.mulRaw(int64(div))
+}
+
+func (d Decimal) mulRaw(i int64) (Decimal, error) {
+	v := int64(d) * i
+	if d != 0 && v/int64(d) != i {
+		return 0, ErrDecimalOverflow
+	}
+	return Decimal(v), nil
+}
+
+// rounds the magnitude q + rem/div of a result
+func roundQuotient(q uint64, rem uint64, div uint64, neg bool, mode RoundingMode) (Decimal, error) {
+	half := -1
+	if rem > div-rem {
+		half = 1
+	} else if rem == div-rem {
+		half = 0
+	}
+	if roundAway(mode, neg, rem != 0, half, q%2 == 1) {
+		q += 1
+	}
+	return signed(q, neg)
+}
+
+// tells if a magnitude must be rounded away from zero, half compares the
+// dropped part with one half
+func roundAway(mode RoundingMode, neg bool, nonzero bool, half int, odd bool) bool {
+	switch mode {
+	case RoundHalfUp:
+		return half >= 0
+	case RoundHalfEven:
+		return half > 0 || (half == 0 && odd)
+	case RoundHalfDown:
+		return half > 0
+	case RoundUp:
+		return nonzero
+	case RoundCeiling:
+		return nonzero && !neg
+	case RoundFloor:
+		return nonzero && neg
+	}
+	return false
+}
+
+func signed(u uint64, neg bool) (Decimal, error) {
+	if neg {
+		if u > 1<<63 {
+			return 0, ErrDecimalOverflow
+		}
+		return Decimal(-int64(u)), nil
+	}
+	if u > math.MaxInt64 {
+		return 0, ErrDecimalOverflow
+	}
+	return Decimal(u), nil
+}
+
+func abs(i int64) (uint64, bool) {
+	if i < 0 {
+		return uint64(-i), true // also right for math.MinInt64
+	}
+	return uint64(i), false
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
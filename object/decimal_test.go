@@ -0,0 +1,141 @@
+package object
+
+import "testing"
+
+// the expected results in the order of the rounding modes:
+// half_up, half_even, half_down, up, down, ceiling, floor
+type byMode [7]string
+
+func dec(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := ParseDecimal(s, RoundHalfUp)
+	if err != nil {
+		t.Fatalf("ParseDecimal(%q): %v", s, err)
+	}
+	return d
+}
+
+func TestParseDecimalRounding(t *testing.T) {
+	tests := []struct {
+		input string
+		want  byMode
+	}{
+		// ties with an even and an odd last digit
+		{"1.00005", byMode{"1.0001", "1.0000", "1.0000", "1.0001", "1.0000", "1.0001", "1.0000"}},
+		{"1.00015", byMode{"1.0002", "1.0002", "1.0001", "1.0002", "1.0001", "1.0002", "1.0001"}},
+		{"-1.00005", byMode{"-1.0001", "-1.0000", "-1.0000", "-1.0001", "-1.0000", "-1.0000", "-1.0001"}},
+		{"-1.00015", byMode{"-1.0002", "-1.0002", "-1.0001", "-1.0002", "-1.0001", "-1.0001", "-1.0002"}},
+		{"-0.00005", byMode{"-0.0001", "0.0000", "0.0000", "-0.0001", "0.0000", "0.0000", "-0.0001"}},
+		// below and above one half
+		{"1.00001", byMode{"1.0000", "1.0000", "1.0000", "1.0001", "1.0000", "1.0001", "1.0000"}},
+		{"-1.00001", byMode{"-1.0000", "-1.0000", "-1.0000", "-1.0001", "-1.0000", "-1.0000", "-1.0001"}},
+		{"1.000050001", byMode{"1.0001", "1.0001", "1.0001", "1.0001", "1.0000", "1.0001", "1.0000"}},
+		{"-1.00009", byMode{"-1.0001", "-1.0001", "-1.0001", "-1.0001", "-1.0000", "-1.0000", "-1.0001"}},
+		// a carry through every digit
+		{"9.99995", byMode{"10.0000", "10.0000", "9.9999", "10.0000", "9.9999", "10.0000", "9.9999"}},
+	}
+	for _, tt := range tests {
+		for m, want := range tt.want {
+			mode := RoundingMode(m)
+			d, err := ParseDecimal(tt.input, mode)
+			if err != nil {
+				t.Errorf("ParseDecimal(%q, %s): %v", tt.input, mode, err)
+				continue
+			}
+			if d.String() != want {
+				t.Errorf("ParseDecimal(%q, %s) = %s, want %s", tt.input, mode, d, want)
+			}
+		}
+	}
+}
+
+func TestMulDivRounding(t *testing.T) {
+	tests := []struct {
+		op   string
+		l, r string
+		want byMode
+	}{
+		// exact results never round
+		{"*", "1.5", "2.5", byMode{"3.7500", "3.7500", "3.7500", "3.7500", "3.7500", "3.7500", "3.7500"}},
+		{"/", "1", "8", byMode{"0.1250", "0.1250", "0.1250", "0.1250", "0.1250", "0.1250", "0.1250"}},
+		// 0.00005 and 0.00015 are ties at the 4-decimal scale
+		{"*", "0.0001", "0.5", byMode{"0.0001", "0.0000", "0.0000", "0.0001", "0.0000", "0.0001", "0.0000"}},
+		{"*", "-0.0001", "0.5", byMode{"-0.0001", "0.0000", "0.0000", "-0.0001", "0.0000", "0.0000", "-0.0001"}},
+		{"*", "0.0003", "0.5", byMode{"0.0002", "0.0002", "0.0001", "0.0002", "0.0001", "0.0002", "0.0001"}},
+		{"*", "0.0003", "-0.5", byMode{"-0.0002", "-0.0002", "-0.0001", "-0.0002", "-0.0001", "-0.0001", "-0.0002"}},
+		{"/", "0.0001", "2", byMode{"0.0001", "0.0000", "0.0000", "0.0001", "0.0000", "0.0001", "0.0000"}},
+		{"/", "-0.0003", "2", byMode{"-0.0002", "-0.0002", "-0.0001", "-0.0002", "-0.0001", "-0.0001", "-0.0002"}},
+		// repeating quotients
+		{"/", "1", "3", byMode{"0.3333", "0.3333", "0.3333", "0.3334", "0.3333", "0.3334", "0.3333"}},
+		{"/", "-2", "3", byMode{"-0.6667", "-0.6667", "-0.6667", "-0.6667", "-0.6666", "-0.6666", "-0.6667"}},
+		{"/", "2", "-3", byMode{"-0.6667", "-0.6667", "-0.6667", "-0.6667", "-0.6666", "-0.6666", "-0.6667"}},
+	}
+	for _, tt := range tests {
+		l, r := dec(t, tt.l), dec(t, tt.r)
+		for m, want := range tt.want {
+			mode := RoundingMode(m)
+			var d Decimal
+			var err error
+			if tt.op == "*" {
+				d, err = l.Mul(r, mode)
+			} else {
+				d, err = l.Div(r, mode)
+			}
+			if err != nil {
+				t.Errorf("%s %s %s (%s): %v", tt.l, tt.op, tt.r, mode, err)
+				continue
+			}
+			if d.String() != want {
+				t.Errorf("%s %s %s (%s) = %s, want %s", tt.l, tt.op, tt.r, mode, d, want)
+			}
+		}
+	}
+}
+
+func TestMulDivErrors(t *testing.T) {
+	big := dec(t, "900000000000000")
+	if _, err := big.Mul(big, RoundHalfUp); err != ErrDecimalOverflow {
+		t.Errorf("overflowing product: got %v, want %v", err, ErrDecimalOverflow)
+	}
+	if _, err := big.Div(dec(t, "0.0001"), RoundHalfUp); err != ErrDecimalOverflow {
+		t.Errorf("overflowing quotient: got %v, want %v", err, ErrDecimalOverflow)
+	}
+	if _, err := big.Div(0, RoundHalfUp); err == nil {
+		t.Errorf("division by zero: got no error")
+	}
+}
+
+func TestSetRound(t *testing.T) {
+	defer func() { DecimalRounding = RoundHalfUp }()
+	DecimalRounding = RoundHalfUp
+
+	for m, name := range roundingNames {
+		prev := DecimalRounding
+		v, err := builtinSetRound(StringValue(name))
+		if err != nil {
+			t.Fatalf("setround(%q): %v", name, err)
+		}
+		if v.AsString() != prev.String() {
+			t.Errorf("setround(%q) returned %q, want the previous mode %q", name, v.AsString(), prev)
+		}
+		if DecimalRounding != RoundingMode(m) {
+			t.Errorf("setround(%q) set %s", name, DecimalRounding)
+		}
+	}
+
+	errors := [][]Value{
+		{},
+		{StringValue("nearest")},
+		{IntValue(1)},
+		{StringValue("up"), StringValue("down")},
+	}
+	for _, args := range errors {
+		before := DecimalRounding
+		if _, err := builtinSetRound(args...); err == nil {
+			t.Errorf("setround(%v) gave no error", args)
+		}
+		if DecimalRounding != before {
+			t.Errorf("setround(%v) changed the mode to %s", args, DecimalRounding)
+		}
+	}
+}
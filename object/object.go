@@ -0,0 +1,42 @@
+package object
+
+import "fmt"
+
+// CompiledFunction is the code object produced for a 'func' declaration,
+// it lives in the constant pool and is what closures are built from.
+type CompiledFunction struct {
+	Name         string
+	Arity        int
+	Instructions []byte
+	Upvalues     []UpvalueInfo
+	Lines        []byte // line table of Instructions, see code.EncodeLines
+}
+
+func (fn *CompiledFunction) String() string {
+	return fmt.Sprintf("<func %s>", fn.Name)
+}
+
+// UpvalueInfo tells the CLOSURE instruction where to capture an upvalue
+// from: a local slot of the enclosing frame or one of its own upvalues.
+type UpvalueInfo struct {
+	IsLocal bool
+	Index   int
+}
+
+// Closure is a function together with the variables it captured.
+type Closure struct {
+	Fn       *CompiledFunction
+	Upvalues []*Upvalue
+}
+
+func (cl *Closure) String() string {
+	return cl.Fn.String()
+}
+
+// Upvalue is a captured variable. While open it refers to a live stack
+// slot, once the slot goes away the value is moved into the upvalue.
+type Upvalue struct {
+	Slot   int
+	Value  Value
+	Closed bool
+}
@@ -0,0 +1,178 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+type ValueKind byte
+
+const (
+	NilKind ValueKind = iota
+	BoolKind
+	IntKind // numeric kinds are ordered by promotion: int -> decimal -> float
+	DecimalKind
+	FloatKind
+	StringKind
+	ObjectKind // functions, closures and builtins
+)
+
+var kindNames = []string{"nil", "bool", "int", "decimal", "float", "string", "function"}
+
+func (k ValueKind) String() string {
+	return kindNames[k]
+}
+
+// Value is what the constant pool, the globals and the VM stack hold.
+// Numbers and bools live in the payload so they never allocate, strings and
+// functions are pointers to the heap.
+type Value struct {
+	Kind ValueKind
+	num  uint64      // bool, int64, Decimal or float64 bits, 1 for an interned string
+	obj  interface{} // *string, *CompiledFunction, *Closure or *Builtin
+}
+
+var Nil = Value{}
+
+func BoolValue(b bool) Value {
+	if b {
+		return Value{Kind: BoolKind, num: 1}
+	}
+	return Value{Kind: BoolKind}
+}
+
+func IntValue(i int64) Value {
+	return Value{Kind: IntKind, num: uint64(i)}
+}
+
+func DecimalValue(d Decimal) Value {
+	return Value{Kind: DecimalKind, num: uint64(d)}
+}
+
+func FloatValue(f float64) Value {
+	return Value{Kind: FloatKind, num: math.Float64bits(f)}
+}
+
+func StringValue(s string) Value {
+	return Value{Kind: StringKind, obj: &s}
+}
+
+// strings interned so far, each one is held by a single pointer. Only
+// constants are interned, by the compiler, the assembler and Load, so the
+// table grows with the distinct string literals and not with the strings
+// programs build. It is shared by every VM to keep the pointers unique.
+var interned = struct {
+	sync.Mutex
+	strings map[string]*string
+}{strings: map[string]*string{}}
+
+// Intern returns the value every interned copy of s shares, two interned
+// strings are equal only when they are the same pointer
+func Intern(s string) Value {
+	interned.Lock()
+	defer interned.Unlock()
+	p, ok := interned.strings[s]
+	if !ok {
+		p = &s
+		interned.strings[s] = p
+	}
+	return Value{Kind: StringKind, num: 1, obj: p}
+}
+
+func (v Value) IsInterned() bool {
+	return v.Kind == StringKind && v.num == 1
+}
+
+func ObjectValue(o interface{}) Value {
+	return Value{Kind: ObjectKind, obj: o}
+}
+
+func (v Value) AsBool() bool {
+	return v.num != 0
+}
+
+func (v Value) AsInt() int64 {
+	return int64(v.num)
+}
+
+func (v Value) AsDecimal() Decimal {
+	return Decimal(v.num)
+}
+
+func (v Value) AsFloat() float64 {
+	return math.Float64frombits(v.num)
+}
+
+func (v Value) AsString() string {
+	return *v.obj.(*string)
+}
+
+func (v Value) AsObject() interface{} {
+	return v.obj
+}
+
+func (v Value) IsNumber() bool {
+	return v.Kind >= IntKind && v.Kind <= FloatKind
+}
+
+// Equals compares two values of any kind, different kinds are never equal
+func (v Value) Equals(o Value) bool {
+	if v.Kind != o.Kind {
+		return false
+	}
+	switch v.Kind {
+	case FloatKind:
+		return v.AsFloat() == o.AsFloat()
+	case StringKind:
+		if v.obj == o.obj {
+			return true
+		}
+		if v.IsInterned() && o.IsInterned() {
+			return false
+		}
+		return v.AsString() == o.AsString()
+	case ObjectKind:
+		return v.obj == o.obj
+	}
+	return v.num == o.num
+}
+
+// ConstKey identifies a constant by its kind and exact value, floats by
+// their bits so 0.0 and -0.0 stay apart
+type ConstKey struct {
+	Kind ValueKind
+	num  uint64
+	str  string
+}
+
+// Key returns the key of a constant, functions have none: two of them are
+// never the same constant
+func (v Value) Key() (ConstKey, bool) {
+	switch v.Kind {
+	case ObjectKind:
+		return ConstKey{}, false
+	case StringKind:
+		return ConstKey{Kind: StringKind, str: v.AsString()}, true
+	}
+	return ConstKey{Kind: v.Kind, num: v.num}, true
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case NilKind:
+		return "<nil>"
+	case BoolKind:
+		return strconv.FormatBool(v.AsBool())
+	case IntKind:
+		return strconv.FormatInt(v.AsInt(), 10)
+	case DecimalKind:
+		return v.AsDecimal().String()
+	case FloatKind:
+		return strconv.FormatFloat(v.AsFloat(), 'g', -1, 64)
+	case StringKind:
+		return v.AsString()
+	}
+	return fmt.Sprintf("%v", v.obj)
+}
@@ -0,0 +1,48 @@
+package object
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStringEquals(t *testing.T) {
+	tests := []struct {
+		name string
+		l, r Value
+		want bool
+	}{
+		{"same interned string", Intern("abc"), Intern("abc"), true},
+		{"different interned strings", Intern("abc"), Intern("abd"), false},
+		{"interned and built", Intern("abc"), StringValue("abc"), true},
+		{"built and interned", StringValue("abd"), Intern("abc"), false},
+		{"two built strings", StringValue("abc"), StringValue("abc"), true},
+		{"string and number", Intern("1"), IntValue(1), false},
+	}
+	for _, tt := range tests {
+		if got := tt.l.Equals(tt.r); got != tt.want {
+			t.Errorf("%s: %v == %v is %v, want %v", tt.name, tt.l, tt.r, got, tt.want)
+		}
+	}
+}
+
+// VMs may run and intern at the same time, run with -race
+func TestInternConcurrently(t *testing.T) {
+	const n = 8
+	values := make([]Value, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				values[i] = Intern("shared")
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, v := range values {
+		if !v.IsInterned() || v.AsObject() != values[0].AsObject() {
+			t.Errorf("goroutine %d got another pointer", i)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package optimizer
+
+import (
+	"vmlite/code"
+	"vmlite/compiler"
+	"vmlite/lexer"
+	"vmlite/object"
+	"vmlite/parser"
+)
+
+// Build parses, optimizes and compiles src at the given level. names and
+// consts are the globals and the constant pool of the code compiled before,
+// the REPL passes the previous lines back in, and only the functions this
+// compilation adds to the pool are optimized. The errors of the first
+// stage that fails are returned.
+func Build(src string, names []string, consts []object.Value, level Level) (*code.Bytecode, []string) {
+	p := parser.NewParser(lexer.NewLexer(src))
+	program := p.Program()
+	if len(p.Errors()) > 0 {
+		return nil, p.Errors()
+	}
+	if level > O0 {
+		o := NewOptimizer(names)
+		program = o.Optimize(program)
+		if len(o.Errors()) > 0 {
+			return nil, o.Errors()
+		}
+	}
+	c := compiler.NewCompiler(names, consts)
+	c.Compile(program)
+	if len(c.Errors()) > 0 {
+		return nil, c.Errors()
+	}
+	bc := c.Bytecode()
+	Peephole(bc, level, len(consts))
+	return bc, nil
+}
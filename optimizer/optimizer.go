@@ -0,0 +1,341 @@
+package optimizer
+
+import (
+	"fmt"
+	"vmlite/ast"
+	"vmlite/code"
+	"vmlite/compiler"
+	"vmlite/object"
+	"vmlite/token"
+	"vmlite/vm"
+)
+
+// Optimizer runs between the parser and the compiler: it folds constant
+// expressions into literals and simplifies identities like x * 1. Constants
+// are computed by vm.Apply so a folded expression gives exactly what the VM
+// would, and an operation that would fail at run time, like a division by
+// zero, is reported as a compile-time error.
+type Optimizer struct {
+	names  []string // globals of the previous REPL lines
+	types  *compiler.Types
+	errors []string
+}
+
+func NewOptimizer(co_names []string) *Optimizer {
+	return &Optimizer{names: co_names, errors: []string{}}
+}
+
+func (o *Optimizer) Errors() []string {
+	return o.errors
+}
+
+// Optimize rewrites the program in place and returns it
+func (o *Optimizer) Optimize(program []ast.Stmt) []ast.Stmt {
+	// identities only hold for some types: x + 0 is not x when x is a string
+	o.types = compiler.NewChecker(o.names).Check(program)
+	o.block(program)
+	return program
+}
+
+// Statements Visitor and Executor
+func (o *Optimizer) executeStmt(stmt ast.Stmt) interface{} {
+	return stmt.Accept(o)
+}
+
+func (o *Optimizer) VisitVarStmt(stmt *ast.VarStmt) interface{} {
+	stmt.Value = o.evaluateExpr(stmt.Value)
+	return nil
+}
+
+func (o *Optimizer) VisitExprStmt(stmt *ast.ExprStmt) interface{} {
+	stmt.Expression = o.evaluateExpr(stmt.Expression)
+	return nil
+}
+
+func (o *Optimizer) VisitPrintStmt(stmt *ast.PrintStmt) interface{} {
+	stmt.Value = o.evaluateExpr(stmt.Value)
+	return nil
+}
+
+func (o *Optimizer) VisitIfStmt(stmt *ast.IfStmt) interface{} {
+	stmt.Condition = o.evaluateExpr(stmt.Condition)
+	o.block(stmt.ThenBranch)
+	o.block(stmt.ElseBranch)
+	return nil
+}
+
+func (o *Optimizer) VisitWhileStmt(stmt *ast.WhileStmt) interface{} {
+	stmt.Condition = o.evaluateExpr(stmt.Condition)
+	o.block(stmt.Body)
+	return nil
+}
+
+func (o *Optimizer) VisitForStmt(stmt *ast.ForStmt) interface{} {
+	stmt.Start = o.evaluateExpr(stmt.Start)
+	stmt.Limit = o.evaluateExpr(stmt.Limit)
+	if stmt.Step != nil {
+		stmt.Step = o.evaluateExpr(stmt.Step)
+	}
+	o.block(stmt.Body)
+	return nil
+}
+
+func (o *Optimizer) VisitBreakStmt(stmt *ast.BreakStmt) interface{} {
+	return nil
+}
+
+func (o *Optimizer) VisitContinueStmt(stmt *ast.ContinueStmt) interface{} {
+	return nil
+}
+
+func (o *Optimizer) VisitBlockStmt(stmt *ast.BlockStmt) interface{} {
+	o.block(stmt.Statements)
+	return nil
+}
+
+func (o *Optimizer) VisitFuncStmt(stmt *ast.FuncStmt) interface{} {
+	o.block(stmt.Body)
+	return nil
+}
+
+func (o *Optimizer) VisitReturnStmt(stmt *ast.ReturnStmt) interface{} {
+	if stmt.Value != nil {
+		stmt.Value = o.evaluateExpr(stmt.Value)
+	}
+	return nil
+}
+
+func (o *Optimizer) block(block []ast.Stmt) {
+	for _, stmt := range block {
+		o.executeStmt(stmt)
+	}
+}
+
+// Expressions Visitor and Evaluator, each expression is replaced by what
+// its visitor returns
+func (o *Optimizer) evaluateExpr(expr ast.Expr) ast.Expr {
+	return expr.Accept(o).(ast.Expr)
+}
+
+func (o *Optimizer) VisitUnaryExpr(expr *ast.Unary) interface{} {
+	expr.Right = o.evaluateExpr(expr.Right)
+	if v, ok := constant(expr.Right); ok {
+		return o.fold(expr, expr.Operator, unaryOps[expr.Operator.Type], v)
+	}
+
+	// not not b is b, as long as b is a boolean
+	if inner, ok := expr.Right.(*ast.Unary); ok && expr.Operator.Type == token.NOT && inner.Operator.Type == token.NOT {
+		if o.typeOf(inner.Right) == compiler.Bool {
+			return inner.Right
+		}
+	}
+	return expr
+}
+
+func (o *Optimizer) VisitBinaryExpr(expr *ast.Binary) interface{} {
+	expr.Left = o.evaluateExpr(expr.Left)
+	expr.Right = o.evaluateExpr(expr.Right)
+	op := expr.Operator.Type
+	l, lok := constant(expr.Left)
+	r, rok := constant(expr.Right)
+	if lok && rok {
+		// decimal products and quotients depend on the rounding mode, which
+		// setround() may change before they run
+		kind := l.Kind
+		if r.Kind > kind {
+			kind = r.Kind
+		}
+		if kind == object.DecimalKind && (op == token.MUL || op == token.DIV) {
+			return expr
+		}
+		return o.fold(expr, expr.Operator, binaryOps[op], l, r)
+	}
+	return o.simplify(expr)
+}
+
+func (o *Optimizer) VisitCallExpr(expr *ast.Call) interface{} {
+	expr.Callee = o.evaluateExpr(expr.Callee)
+	for i, arg := range expr.Arguments {
+		expr.Arguments[i] = o.evaluateExpr(arg)
+	}
+	return expr
+}
+
+func (o *Optimizer) VisitAssignExpr(expr *ast.Assign) interface{} {
+	expr.Value = o.evaluateExpr(expr.Value)
+	return expr
+}
+
+func (o *Optimizer) VisitIdentifierExpr(expr *ast.Identifier) interface{} {
+	return expr
+}
+
+func (o *Optimizer) VisitLiteralExpr(expr *ast.Literal) interface{} {
+	return expr
+}
+
+/****************************
+* OPTIMIZER HELPER FUNCTIONS
+*****************************/
+
+// the generic opcode of each operator, vm.Apply promotes the operands
+var binaryOps = map[token.TokenType]code.Opcode{
+	token.PLUS:  code.ADD,
+	token.MINUS: code.SUB,
+	token.MUL:   code.MUL,
+	token.DIV:   code.DIV,
+	token.IDIV:  code.IDIV,
+	token.MOD:   code.MOD,
+	token.LT:    code.LT,
+	token.GT:    code.GT,
+	token.LEQ:   code.LEQ,
+	token.GEQ:   code.GEQ,
+	token.EQ:    code.EQ,
+	token.NEQ:   code.NEQ,
+	token.AND:   code.AND,
+	token.OR:    code.OR,
+}
+
+var unaryOps = map[token.TokenType]code.Opcode{
+	token.MINUS: code.NEG,
+	token.NOT:   code.NOT,
+}
+
+// replaces expr by the literal result of op, invalid operands are left to
+// the checker which reports them
+func (o *Optimizer) fold(expr ast.Expr, operator token.Token, op code.Opcode, operands ...object.Value) ast.Expr {
+	v, err := vm.Apply(op, operands...)
+	if err != nil {
+		if _, ok := err.(*vm.TypeError); !ok {
+			o.errors = append(o.errors, fmt.Sprintf("%s in a constant expression at Ln: %d, Col: %d.", err, operator.Ln, operator.Col))
+		}
+		return expr
+	}
+	return literal(v, operator)
+}
+
+// drops the neutral element of an operation when the result keeps the
+// type and the value of the other operand
+func (o *Optimizer) simplify(expr *ast.Binary) ast.Expr {
+	l, r := expr.Left, expr.Right
+	lt, rt := o.typeOf(l), o.typeOf(r)
+	switch expr.Operator.Type {
+	case token.PLUS:
+		// -0.0 + 0 is 0.0, floats are not simplified
+		if isInt(r, 0) && (lt == compiler.Int || lt == compiler.Decimal) {
+			return l
+		}
+		if isInt(l, 0) && (rt == compiler.Int || rt == compiler.Decimal) {
+			return r
+		}
+	case token.MINUS:
+		if isInt(r, 0) && numeric(lt) {
+			return l
+		}
+	case token.MUL:
+		if isInt(r, 1) && numeric(lt) {
+			return l
+		}
+		if isInt(l, 1) && numeric(rt) {
+			return r
+		}
+	case token.AND:
+		// the other operand is always evaluated, only a constant is dropped
+		if isBool(r, true) && lt == compiler.Bool {
+			return l
+		}
+		if isBool(l, true) && rt == compiler.Bool {
+			return r
+		}
+	case token.OR:
+		if isBool(r, false) && lt == compiler.Bool {
+			return l
+		}
+		if isBool(l, false) && rt == compiler.Bool {
+			return r
+		}
+	}
+	return expr
+}
+
+// the checker knows the type of the original nodes, folded ones are
+// literals
+func (o *Optimizer) typeOf(expr ast.Expr) compiler.Type {
+	if v, ok := constant(expr); ok {
+		switch v.Kind {
+		case object.IntKind:
+			return compiler.Int
+		case object.DecimalKind:
+			return compiler.Decimal
+		case object.FloatKind:
+			return compiler.Float
+		case object.StringKind:
+			return compiler.String
+		case object.BoolKind:
+			return compiler.Bool
+		}
+	}
+	return o.types.TypeOf(expr)
+}
+
+func numeric(t compiler.Type) bool {
+	return t == compiler.Int || t == compiler.Decimal || t == compiler.Float
+}
+
+// the value of a literal
+func constant(expr ast.Expr) (object.Value, bool) {
+	lit, ok := expr.(*ast.Literal)
+	if !ok {
+		return object.Nil, false
+	}
+	switch lit.Token.Type {
+	case token.TRUE:
+		return object.BoolValue(true), true
+	case token.FALSE:
+		return object.BoolValue(false), true
+	case token.STRING:
+		return object.StringValue(lit.Token.Lexeme.(string)), true
+	case token.NUMBER:
+		switch v := lit.Token.Lexeme.(type) {
+		case int64:
+			return object.IntValue(v), true
+		case object.Decimal:
+			return object.DecimalValue(v), true
+		case float64:
+			return object.FloatValue(v), true
+		}
+	}
+	return object.Nil, false
+}
+
+// builds the literal of a folded value at the position of its operator
+func literal(v object.Value, pos token.Token) ast.Expr {
+	var tok token.Token
+	switch v.Kind {
+	case object.BoolKind:
+		if v.AsBool() {
+			tok = token.NewToken(pos.Ln, pos.Col, token.TRUE, "true")
+		} else {
+			tok = token.NewToken(pos.Ln, pos.Col, token.FALSE, "false")
+		}
+	case object.IntKind:
+		tok = token.NewToken(pos.Ln, pos.Col, token.NUMBER, v.AsInt())
+	case object.DecimalKind:
+		tok = token.NewToken(pos.Ln, pos.Col, token.NUMBER, v.AsDecimal())
+	case object.FloatKind:
+		tok = token.NewToken(pos.Ln, pos.Col, token.NUMBER, v.AsFloat())
+	case object.StringKind:
+		tok = token.NewToken(pos.Ln, pos.Col, token.STRING, v.AsString())
+	}
+	return &ast.Literal{Token: tok}
+}
+
+func isInt(expr ast.Expr, i int64) bool {
+	v, ok := constant(expr)
+	return ok && v.Kind == object.IntKind && v.AsInt() == i
+}
+
+func isBool(expr ast.Expr, b bool) bool {
+	v, ok := constant(expr)
+	return ok && v.Kind == object.BoolKind && v.AsBool() == b
+}
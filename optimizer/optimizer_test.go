@@ -0,0 +1,114 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+	"vmlite/ast"
+	"vmlite/lexer"
+	"vmlite/parser"
+)
+
+// optimizes src and prints the program on one line
+func optimize(t *testing.T, src string) (string, []string) {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src))
+	program := p.Program()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	o := NewOptimizer([]string{})
+	program = o.Optimize(program)
+	return strings.Join(strings.Fields(ast.NewAstPrinter().Print(program)), " "), o.Errors()
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"arithmetic", `var r = 2 * 3 + 4`, `var r = 10`},
+		{"nested", `var r = 1 + 2 * 0`, `var r = 1`},
+		{"strings", `var r = "a" + "b"`, `var r = 'ab'`},
+		{"comparison", `var r = 1 < 2`, `var r = 'true'`},
+		{"promoted comparison", `var r = 2 == 2.0`, `var r = 'true'`},
+		{"not", `var r = !true`, `var r = 'false'`},
+		{"floored division", `var r = -7 // 2`, `var r = -4`},
+		{"floored modulo", `var r = -7 % 2`, `var r = 1`},
+		{"int and float", `var r = 1 + 2.5`, `var r = 3.5`},
+		{"decimal sum", `var r = $1.5 + 2`, `var r = 3.5000`},
+		// products and quotients depend on the rounding mode at run time
+		{"decimal product", `var r = $1.5 * 2`, `var r = (1.5000 * 2)`},
+		{"decimal quotient", `var r = $1.5 / 2`, `var r = (1.5000 / 2)`},
+	}
+	for _, tt := range tests {
+		got, errors := optimize(t, tt.src)
+		if len(errors) > 0 {
+			t.Errorf("%s: %v", tt.name, errors)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"int times one", `do var x = 1 print x * 1 end`, `do var x = 1 print(x) end`},
+		{"one times int", `do var x = 1 print 1 * x end`, `do var x = 1 print(x) end`},
+		{"int plus zero", `do var x = 1 print x + 0 end`, `do var x = 1 print(x) end`},
+		{"zero plus int", `do var x = 1 print 0 + x end`, `do var x = 1 print(x) end`},
+		{"int minus zero", `do var x = 1 print x - 0 end`, `do var x = 1 print(x) end`},
+		{"decimal plus zero", `do var d = $1 print d + 0 end`, `do var d = 1.0000 print(d) end`},
+		{"folded one", `do var x = 2 print x * (3 - 2) end`, `do var x = 2 print(x) end`},
+		{"float times one", `do var x = 1.5 print x * 1 end`, `do var x = 1.5 print(x) end`},
+		{"and true", `do var b = true print b and true end`, `do var b = 'true' print(b) end`},
+		{"true and", `do var b = true print true and b end`, `do var b = 'true' print(b) end`},
+		{"or false", `do var b = true print b or false end`, `do var b = 'true' print(b) end`},
+		{"double not", `do var b = true print !!b end`, `do var b = 'true' print(b) end`},
+
+		// -0.0 + 0 is 0.0
+		{"float plus zero", `do var x = 1.5 print x + 0 end`, `do var x = 1.5 print((x + 0)) end`},
+		{"zero plus float", `do var x = 1.5 print 0 + x end`, `do var x = 1.5 print((0 + x)) end`},
+		{"string plus zero", `do var s = "a" print s + 0 end`, `do var s = 'a' print((s + 0)) end`},
+		{"double not of an int", `do var x = 1 print !!x end`, `do var x = 1 print((! (! x))) end`},
+		// another line may store anything in a global
+		{"global", `var x = 1 print x * 1`, `var x = 1 print((x * 1))`},
+	}
+	for _, tt := range tests {
+		got, errors := optimize(t, tt.src)
+		if len(errors) > 0 {
+			t.Errorf("%s: %v", tt.name, errors)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// an operation that fails at run time is a compile-time error and is left
+// as it is
+func TestFoldErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`var r = 1 // 0`, "division by zero in a constant expression at Ln: 1, Col: 11."},
+		{`var r = 1 % 0`, "division by zero in a constant expression at Ln: 1, Col: 11."},
+		{`var r = 9223372036854775807 + 1`, "integer overflow in a constant expression at Ln: 1, Col: 29."},
+		{`var r = -9223372036854775807 - 2`, "integer overflow in a constant expression at Ln: 1, Col: 30."},
+	}
+	for _, tt := range tests {
+		got, errors := optimize(t, tt.src)
+		if len(errors) != 1 || errors[0] != tt.want {
+			t.Errorf("%s: got errors %v, want %q", tt.src, errors, tt.want)
+		}
+		if !strings.HasPrefix(got, "var r = (") {
+			t.Errorf("%s was rewritten to %s", tt.src, got)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package optimizer
+
+import (
+	"vmlite/code"
+	"vmlite/object"
+)
+
+// Level selects the optimizations, like the -O flags of a C compiler
+type Level int
+
+const (
+	O0 Level = iota // none, the code runs as compiled
+	O1              // constant folding and peephole clean ups
+	O2              // O1 and superinstructions
+)
+
+// ParseLevel reads a -O0, -O1 or -O2 flag
+func ParseLevel(flag string) (Level, bool) {
+	switch flag {
+	case "-O0":
+		return O0, true
+	case "-O1":
+		return O1, true
+	case "-O2":
+		return O2, true
+	}
+	return O0, false
+}
+
+// Peephole rewrites the main code of bc and every function in its constant
+// pool. From O1 on jump chains are collapsed, unreachable code and jumps to
+// the next instruction are removed, STORE x; LOAD x becomes DUP; STORE x
+// and an assignment statement no longer duplicates the value it pops right
+// away. O2 also fuses loads into superinstructions, along with the
+// operator applied to a variable and a constant.
+// The line tables are rebuilt so errors still point to the source.
+//
+// first is the index of the first constant this compilation added: the
+// REPL shares the pool between lines and the functions of previous lines,
+// which may still be running, were optimized already.
+func Peephole(bc *code.Bytecode, level Level, first int) {
+	if level == O0 {
+		return
+	}
+	bc.Instructions, bc.Lines = peephole(bc.Instructions, bc.Lines, level)
+	for _, c := range bc.Constants[first:] {
+		if fn, ok := c.AsObject().(*object.CompiledFunction); ok && c.Kind == object.ObjectKind {
+			fn.Instructions, fn.Lines = peephole(fn.Instructions, fn.Lines, level)
+		}
+	}
+}
+
+// an instruction while the code is rewritten, jumps refer to the index of
+// their target so instructions can be removed and fused
+type inst struct {
+	op       code.Opcode
+	operands []int
+	target   int // index of the jump target, len(code) is the end
+	ln, col  int
+	label    bool // a jump lands here
+	dead     bool
+}
+
+type peepholer struct {
+	code []*inst
+}
+
+func peephole(ins []byte, lines []byte, level Level) ([]byte, []byte) {
+	p := &peepholer{}
+	if !p.decode(ins, lines) {
+		return ins, lines // bad code is left to the verifier
+	}
+	for changed := true; changed; {
+		changed = p.threadJumps()
+		changed = p.removeUnreachable() || changed
+		changed = p.removeJumpsToNext() || changed
+		p.compact()
+		changed = p.storeLoad() || changed
+		changed = p.assignments() || changed
+		p.compact()
+	}
+	if level >= O2 {
+		p.fuse()
+		p.compact()
+	}
+	return p.encode()
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.JUMP || op == code.JUMPF
+}
+
+func (p *peepholer) decode(ins []byte, lines []byte) bool {
+	index := map[int]int{} // offset -> instruction
+	offsets := []int{}
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		size := code.InstructionSize(ins[ip])
+		if err != nil || ip+size > len(ins) {
+			return false
+		}
+		operands, _ := code.ReadOperands(def, ins[ip+1:])
+		index[ip] = len(p.code)
+		offsets = append(offsets, ip)
+		p.code = append(p.code, &inst{op: ins[ip], operands: operands})
+		ip += size
+	}
+	index[len(ins)] = len(p.code)
+
+	positions := code.DecodeLines(lines)
+	next := 0
+	ln, col := 0, 0
+	for n, i := range p.code {
+		for next < len(positions) && positions[next].Offset <= offsets[n] {
+			ln, col = positions[next].Ln, positions[next].Col
+			next += 1
+		}
+		i.ln, i.col = ln, col
+		if isJump(i.op) {
+			t, ok := index[i.operands[0]]
+			if !ok {
+				return false
+			}
+			i.target = t
+		}
+	}
+	return true
+}
+
+// a jump to a JUMP goes straight to its final target
+func (p *peepholer) threadJumps() bool {
+	changed := false
+	for _, i := range p.code {
+		if !isJump(i.op) {
+			continue
+		}
+		t := i.target
+		for hops := 0; t < len(p.code) && p.code[t].op == code.JUMP && hops < len(p.code); hops++ {
+			t = p.code[t].target
+		}
+		if t != i.target {
+			i.target = t
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removes the code no path reaches, like a NIL RETURN after a 'return'
+func (p *peepholer) removeUnreachable() bool {
+	reached := make([]bool, len(p.code)+1)
+	work := []int{0}
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		if reached[n] {
+			continue
+		}
+		reached[n] = true
+		if n == len(p.code) {
+			continue
+		}
+		i := p.code[n]
+		if isJump(i.op) {
+			work = append(work, i.target)
+		}
+		if i.op != code.JUMP && i.op != code.RETURN {
+			work = append(work, n+1)
+		}
+	}
+	changed := false
+	for n, i := range p.code {
+		if !reached[n] {
+			i.dead = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *peepholer) removeJumpsToNext() bool {
+	changed := false
+	for n, i := range p.code {
+		if i.op == code.JUMP && !i.dead && p.nextLive(n) == i.target {
+			i.dead = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// STORE x; LOAD x becomes DUP; STORE x, the value is not read back
+var reloads = map[code.Opcode]code.Opcode{
+	code.STORE:         code.LOAD,
+	code.STORE_LOCAL:   code.LOAD_LOCAL,
+	code.STORE_UPVALUE: code.LOAD_UPVALUE,
+}
+
+func (p *peepholer) storeLoad() bool {
+	changed := false
+	for n := 0; n+1 < len(p.code); n++ {
+		store, load := p.code[n], p.code[n+1]
+		if reloads[store.op] != load.op || load.op == 0 || load.label || store.operands[0] != load.operands[0] {
+			continue
+		}
+		p.code[n+1] = &inst{op: store.op, operands: store.operands, ln: store.ln, col: store.col}
+		p.code[n] = &inst{op: code.DUP, ln: store.ln, col: store.col, label: store.label}
+		changed = true
+	}
+	return changed
+}
+
+// an assignment statement compiles to DUP; STORE x; POP, it is just STORE x
+func (p *peepholer) assignments() bool {
+	changed := false
+	for n := 0; n+2 < len(p.code); n++ {
+		dup, store, pop := p.code[n], p.code[n+1], p.code[n+2]
+		if dup.op != code.DUP || pop.op != code.POP || store.label || pop.label {
+			continue
+		}
+		if _, ok := reloads[store.op]; !ok {
+			continue
+		}
+		store.label = dup.label
+		p.code[n], p.code[n+1] = store, dup
+		dup.dead, pop.dead = true, true
+		changed = true
+		n += 2
+	}
+	return changed
+}
+
+// the superinstructions, each one replaces a pair of instructions
+var fusions = []struct {
+	first  []code.Opcode
+	second []code.Opcode
+	op     code.Opcode
+}{
+	{[]code.Opcode{code.LOAD_LOCAL}, []code.Opcode{code.LOAD_LOCAL}, code.LOAD_LOCAL2},
+	{[]code.Opcode{code.LOAD_LOCAL}, []code.Opcode{code.PUSHI, code.PUSHF, code.PUSHD, code.PUSHS}, code.LOAD_LOCAL_CONST},
+	{[]code.Opcode{code.LOAD}, []code.Opcode{code.PUSHI, code.PUSHF, code.PUSHD, code.PUSHS}, code.LOAD_CONST},
+}
+
+// a pair fused with the binary operator that follows it, like
+// LOAD_LOCAL i; PUSHI k; ADDI for i + 1
+var operatorFusions = map[code.Opcode]code.Opcode{
+	code.LOAD_LOCAL_CONST: code.LOAD_LOCAL_CONST_OP,
+	code.LOAD_CONST:       code.LOAD_CONST_OP,
+}
+
+func (p *peepholer) fuse() {
+	for n := 0; n+1 < len(p.code); n++ {
+		first, second := p.code[n], p.code[n+1]
+		if second.label {
+			continue
+		}
+		for _, f := range fusions {
+			if contains(f.first, first.op) && contains(f.second, second.op) {
+				first.op = f.op
+				first.operands = []int{first.operands[0], second.operands[0]}
+				second.dead = true
+				n += 1
+				if p.fuseOperator(first, n+1) {
+					n += 1
+				}
+				break
+			}
+		}
+	}
+}
+
+// fuses the freshly built pair with the binary operator at index n
+func (p *peepholer) fuseOperator(pair *inst, n int) bool {
+	op, ok := operatorFusions[pair.op]
+	if !ok || n >= len(p.code) || p.code[n].label || !code.IsBinary(p.code[n].op) {
+		return false
+	}
+	operator := p.code[n]
+	pair.op = op
+	pair.operands = append(pair.operands, int(operator.op))
+	// the operator is what fails on bad values, errors point to it
+	pair.ln, pair.col = operator.ln, operator.col
+	operator.dead = true
+	return true
+}
+
+func contains(ops []code.Opcode, op code.Opcode) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// index of the first live instruction from n+1 on
+func (p *peepholer) nextLive(n int) int {
+	for n += 1; n < len(p.code) && p.code[n].dead; n++ {
+	}
+	return n
+}
+
+// drops the dead instructions, jumps to them move to the next live one,
+// and marks the jump targets
+func (p *peepholer) compact() {
+	index := make([]int, len(p.code)+1)
+	live := []*inst{}
+	for n, i := range p.code {
+		index[n] = len(live)
+		if !i.dead {
+			live = append(live, i)
+		}
+	}
+	index[len(p.code)] = len(live)
+	for _, i := range live {
+		i.label = false
+	}
+	for _, i := range live {
+		if isJump(i.op) {
+			i.target = index[i.target]
+			if i.target < len(live) {
+				live[i.target].label = true
+			}
+		}
+	}
+	p.code = live
+}
+
+func (p *peepholer) encode() ([]byte, []byte) {
+	offsets := make([]int, len(p.code)+1)
+	for n, i := range p.code {
+		offsets[n+1] = offsets[n] + code.InstructionSize(i.op)
+	}
+	ins := []byte{}
+	positions := []code.Position{}
+	for n, i := range p.code {
+		operands := i.operands
+		if isJump(i.op) {
+			operands = []int{offsets[i.target]}
+		}
+		ins = append(ins, code.Make(i.op, operands...)...)
+		last := len(positions) - 1
+		if i.ln > 0 && (last < 0 || positions[last].Ln != i.ln || positions[last].Col != i.col) {
+			positions = append(positions, code.Position{Offset: offsets[n], Ln: i.ln, Col: i.col})
+		}
+	}
+	return ins, code.EncodeLines(positions)
+}
@@ -0,0 +1,205 @@
+package optimizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"vmlite/code"
+	"vmlite/object"
+	"vmlite/vm"
+)
+
+// compiles src at the given level and returns what it prints
+func runAt(t *testing.T, src string, level Level) string {
+	t.Helper()
+	bc, errors := Build(src, []string{}, []object.Value{}, level)
+	if len(errors) > 0 {
+		t.Fatalf("-O%d: %v", level, errors)
+	}
+	machine, err := vm.NewVMFromBytecode(bc, make([]object.Value, 64))
+	if err != nil {
+		t.Fatalf("-O%d: %v", level, err)
+	}
+
+	var out bytes.Buffer
+	machine.SetOutput(&out)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("-O%d: %v", level, err)
+	}
+	return out.String()
+}
+
+// every level prints the same
+func TestLevelsAgree(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		// LOAD_LOCAL2 reading the slot its first load pushes
+		{"block local from local", `do var a = 1 var b = a print b end`, "1\n"},
+		{"function local from local", `func f(a) var t = a var u = t return u end print f(7)`, "7\n"},
+		{"local pairs", `func f(a, b) var c = a + b var d = c var e = d return c * d + e end print f(1, 2)`, "12\n"},
+		{"loops", `
+func g(n)
+  var s = 0
+  for i = 1 to n
+    if i % 2 == 0
+      continue
+    endif
+    if i > 7
+      break
+    endif
+    s = s + i
+  endfor
+  while s > 100
+    s = s - 1
+  endwhile
+  if s > 3
+    return s
+  else
+    return 0
+  endif
+end
+print g(10)
+print g(2)
+var k = 0
+while k < 3
+  if k == 1
+    print "one"
+  else
+    print k
+  endif
+  k += 1
+endwhile`, "16\n0\n0\none\n2\n"},
+		{"closures", `
+func counter()
+  var n = 0
+  func inc()
+    n += 1
+    return n
+  end
+  return inc
+end
+var c = counter()
+c()
+print c()`, "2\n"},
+	}
+	for _, tt := range tests {
+		for _, level := range []Level{O0, O1, O2} {
+			if got := runAt(t, tt.src, level); got != tt.want {
+				t.Errorf("%s at -O%d printed %q, want %q", tt.name, level, got, tt.want)
+			}
+		}
+	}
+}
+
+// the REPL shares the pool between lines, a later line must leave the
+// functions of the previous ones alone
+func TestPeepholeSkipsPreviousFunctions(t *testing.T) {
+	compile := func(src string, names []string, consts []object.Value) *code.Bytecode {
+		bc, errors := Build(src, names, consts, O2)
+		if len(errors) > 0 {
+			t.Fatalf("errors: %v", errors)
+		}
+		return bc
+	}
+
+	first := compile(`func f(a) var t = a return t end`, []string{}, []object.Value{})
+	var fn *object.CompiledFunction
+	for _, c := range first.Constants {
+		if f, ok := c.AsObject().(*object.CompiledFunction); ok {
+			fn = f
+		}
+	}
+	optimized := fn.Instructions
+
+	second := compile(`func g(a) var t = a return t end print f(1) + g(2)`, first.Names, first.Constants)
+	if &fn.Instructions[0] != &optimized[0] {
+		t.Errorf("the second line rewrote f")
+	}
+	for _, c := range second.Constants[len(first.Constants):] {
+		if g, ok := c.AsObject().(*object.CompiledFunction); ok && g.Name == "g" {
+			if !bytes.Equal(g.Instructions, optimized) {
+				t.Errorf("g was not optimized:\n%s", code.DisassembleCode(g.Instructions, nil, second.Constants, second.Names))
+			}
+		}
+	}
+}
+
+// the REPL shows the value of the last top-level expression statement, the
+// same at every level, and nothing if there is none
+func TestLastPopped(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"expression", `1 + 2`, "3"},
+		{"block local", `do var b = 2 end`, ""},
+		{"assignment", `var x = 1 x = 5`, ""},
+		{"expression before an assignment", `var x = 1 x + 1 x = 5`, "2"},
+		{"expression in a block", `do 7 end`, ""},
+		{"assignment in a function", `func f(n) n += 1 end var r = f(1)`, ""},
+		{"call", `func f(n) n += 1 return n end f(1)`, "2"},
+	}
+	for _, tt := range tests {
+		for _, level := range []Level{O0, O1, O2} {
+			bc, errors := Build(tt.src, []string{}, []object.Value{}, level)
+			if len(errors) > 0 {
+				t.Fatalf("%s at -O%d: %v", tt.name, level, errors)
+			}
+			machine, err := vm.NewVMFromBytecode(bc, make([]object.Value, 64))
+			if err == nil {
+				err = machine.Run()
+			}
+			if err != nil {
+				t.Fatalf("%s at -O%d: %v", tt.name, level, err)
+			}
+			got := ""
+			if last := machine.LastPopped(); last.Kind != object.NilKind {
+				got = last.String()
+			}
+			if got != tt.want {
+				t.Errorf("%s at -O%d shows %q, want %q", tt.name, level, got, tt.want)
+			}
+		}
+	}
+}
+
+// a variable, a constant and the operator between them run as one
+// instruction that fails where the operator is
+func TestFuseOperator(t *testing.T) {
+	src := `func f(i) return i + 1 end var r = f(9223372036854775807)`
+	bc, problems := Build(src, []string{}, []object.Value{}, O2)
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	for _, c := range bc.Constants {
+		if fn, ok := c.AsObject().(*object.CompiledFunction); ok && c.Kind == object.ObjectKind {
+			want := code.Make(code.LOAD_LOCAL_CONST_OP, 0, 0, int(code.ADD))
+			want = append(want, code.Make(code.RETURN)...)
+			if !bytes.Equal(fn.Instructions, want) {
+				t.Errorf("f is\n%s", code.DisassembleCode(fn.Instructions, nil, bc.Constants, bc.Names))
+			}
+		}
+	}
+
+	positions := []string{}
+	for _, level := range []Level{O0, O2} {
+		bc, _ := Build(src, []string{}, []object.Value{}, level)
+		machine, err := vm.NewVMFromBytecode(bc, make([]object.Value, 64))
+		if err == nil {
+			err = machine.Run()
+		}
+		var rerr *vm.RuntimeError
+		if !errors.As(err, &rerr) {
+			t.Fatalf("-O%d: got %v, want a runtime error", level, err)
+		}
+		positions = append(positions, fmt.Sprintf("Ln: %d, Col: %d", rerr.Ln, rerr.Col))
+	}
+	if positions[0] != positions[1] {
+		t.Errorf("the overflow is at %s at -O0 and at %s at -O2", positions[0], positions[1])
+	}
+}
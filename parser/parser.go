@@ -10,6 +10,7 @@ import (
 // precedence order
 const (
 	LOWEST int = iota
+	ASSIGNMENT
 	LOGIC_OR
 	LOGIC_AND
 	EQUALITY
@@ -23,6 +24,12 @@ const (
 
 // precedence map
 var mapPrecedence = map[token.TokenType]int{
+	// assignment
+	token.ASSIGN:       ASSIGNMENT,
+	token.PLUS_ASSIGN:  ASSIGNMENT,
+	token.MINUS_ASSIGN: ASSIGNMENT,
+	token.MUL_ASSIGN:   ASSIGNMENT,
+	token.DIV_ASSIGN:   ASSIGNMENT,
 	// logical operators
 	token.OR:  LOGIC_OR,
 	token.AND: LOGIC_AND,
@@ -38,8 +45,12 @@ var mapPrecedence = map[token.TokenType]int{
 	token.PLUS:  TERM,
 	token.MINUS: TERM,
 	// factor
-	token.MUL: FACTOR,
-	token.DIV: FACTOR,
+	token.MUL:  FACTOR,
+	token.DIV:  FACTOR,
+	token.IDIV: FACTOR,
+	token.MOD:  FACTOR,
+	// call
+	token.LPAREN: CALL,
 }
 
 // semantic function types
@@ -67,7 +78,7 @@ func NewParser(l *lexer.Lexer) *Parser {
 	// register PREFIX semantic code
 	p.registerPrefixFn(token.NUMBER, p.parseLiteral)
 	p.registerPrefixFn(token.STRING, p.parseLiteral)
-	p.registerPrefixFn(token.IDENT, p.parseLiteral)
+	p.registerPrefixFn(token.IDENT, p.parseIdentifier)
 	p.registerPrefixFn(token.TRUE, p.parseLiteral)
 	p.registerPrefixFn(token.FALSE, p.parseLiteral)
 
@@ -80,6 +91,8 @@ func NewParser(l *lexer.Lexer) *Parser {
 	p.registerInfixFn(token.MINUS, p.parseInfixExpr)
 	p.registerInfixFn(token.MUL, p.parseInfixExpr)
 	p.registerInfixFn(token.DIV, p.parseInfixExpr)
+	p.registerInfixFn(token.IDIV, p.parseInfixExpr)
+	p.registerInfixFn(token.MOD, p.parseInfixExpr)
 	p.registerInfixFn(token.OR, p.parseInfixExpr)
 	p.registerInfixFn(token.AND, p.parseInfixExpr)
 	p.registerInfixFn(token.LT, p.parseInfixExpr)
@@ -88,6 +101,12 @@ func NewParser(l *lexer.Lexer) *Parser {
 	p.registerInfixFn(token.GEQ, p.parseInfixExpr)
 	p.registerInfixFn(token.EQ, p.parseInfixExpr)
 	p.registerInfixFn(token.NEQ, p.parseInfixExpr)
+	p.registerInfixFn(token.LPAREN, p.parseCallExpr)
+	p.registerInfixFn(token.ASSIGN, p.parseAssignExpr)
+	p.registerInfixFn(token.PLUS_ASSIGN, p.parseAssignExpr)
+	p.registerInfixFn(token.MINUS_ASSIGN, p.parseAssignExpr)
+	p.registerInfixFn(token.MUL_ASSIGN, p.parseAssignExpr)
+	p.registerInfixFn(token.DIV_ASSIGN, p.parseAssignExpr)
 
 	// move tokens
 	p.nextToken()
@@ -95,8 +114,10 @@ func NewParser(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// lexer errors come first, they usually cause the parser ones
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := append([]string{}, p.l.Errors()...)
+	return append(errors, p.errors...)
 }
 
 func (p *Parser) registerPrefixFn(t token.TokenType, fn PrefixFnType) {
@@ -132,6 +153,22 @@ func (p *Parser) statement() ast.Stmt {
 		return p.varStatement()
 	} else if p.match(token.PRINT) {
 		return p.printStmt()
+	} else if p.match(token.IF) {
+		return p.ifStmt()
+	} else if p.match(token.WHILE) {
+		return p.whileStmt()
+	} else if p.match(token.FOR) {
+		return p.forStmt()
+	} else if p.match(token.DO) {
+		return p.blockStmt()
+	} else if p.match(token.FUNC) {
+		return p.funcStmt()
+	} else if p.match(token.RETURN) {
+		return p.returnStmt()
+	} else if p.match(token.BREAK) {
+		return &ast.BreakStmt{Keyword: p.prevToken}
+	} else if p.match(token.CONTINUE) {
+		return &ast.ContinueStmt{Keyword: p.prevToken}
 	} else {
 		return p.exprStmt()
 	}
@@ -155,6 +192,104 @@ func (p *Parser) printStmt() ast.Stmt {
 	return stmt
 }
 
+func (p *Parser) ifStmt() ast.Stmt {
+	stmt := &ast.IfStmt{Keyword: p.prevToken}
+	stmt.Condition = p.expression(LOWEST)
+	stmt.ThenBranch = p.block(token.ELSE, token.ENDIF)
+
+	if p.match(token.ELSE) {
+		if p.match(token.IF) {
+			// 'else if' chains share the same 'endif'
+			stmt.ElseBranch = []ast.Stmt{p.ifStmt()}
+			return stmt
+		}
+		stmt.ElseBranch = p.block(token.ENDIF)
+	}
+	p.expect(token.ENDIF, "expect 'endif' after 'if' statement.")
+
+	return stmt
+}
+
+func (p *Parser) whileStmt() ast.Stmt {
+	stmt := &ast.WhileStmt{Keyword: p.prevToken}
+	stmt.Condition = p.expression(LOWEST)
+	stmt.Body = p.block(token.ENDWHILE)
+	p.expect(token.ENDWHILE, "expect 'endwhile' after 'while' statement.")
+
+	return stmt
+}
+
+func (p *Parser) forStmt() ast.Stmt {
+	stmt := &ast.ForStmt{}
+	p.expect(token.IDENT, "expect IDENTIFIER after 'for'.")
+	stmt.Name = p.prevToken
+
+	p.expect(token.ASSIGN, "expect '=' after 'for' variable.")
+	stmt.Start = p.expression(LOWEST)
+
+	p.expect(token.TO, "expect 'to' after 'for' initial value.")
+	stmt.Limit = p.expression(LOWEST)
+
+	if p.match(token.STEP) {
+		stmt.Step = p.expression(LOWEST)
+	}
+	stmt.Body = p.block(token.ENDFOR)
+	p.expect(token.ENDFOR, "expect 'endfor' after 'for' statement.")
+
+	return stmt
+}
+
+func (p *Parser) blockStmt() ast.Stmt {
+	stmt := &ast.BlockStmt{}
+	stmt.Statements = p.block(token.END)
+	p.expect(token.END, "expect 'end' after block.")
+
+	return stmt
+}
+
+func (p *Parser) funcStmt() ast.Stmt {
+	stmt := &ast.FuncStmt{}
+	p.expect(token.IDENT, "expect function name after 'func'.")
+	stmt.Name = p.prevToken
+
+	p.expect(token.LPAREN, "expect '(' after function name.")
+	if !p.check(token.RPAREN) {
+		for {
+			p.expect(token.IDENT, "expect parameter name.")
+			stmt.Params = append(stmt.Params, p.prevToken)
+			if !p.match(token.COMMA) {
+				break
+			}
+		}
+	}
+	p.expect(token.RPAREN, "expect ')' after parameters.")
+
+	stmt.Body = p.block(token.END)
+	p.expect(token.END, "expect 'end' after function body.")
+
+	return stmt
+}
+
+func (p *Parser) returnStmt() ast.Stmt {
+	stmt := &ast.ReturnStmt{Keyword: p.prevToken}
+	// there are no statement separators: a value follows only when the
+	// next token can start an expression
+	if _, ok := p.mapPrefixFn[p.curToken.Type]; ok {
+		stmt.Value = p.expression(LOWEST)
+	}
+
+	return stmt
+}
+
+// parses statements until one of the given terminators (or EOF) is found
+func (p *Parser) block(terminators ...token.TokenType) []ast.Stmt {
+	stmts := []ast.Stmt{}
+	for !p.check(terminators...) && !p.check(token.EOF) {
+		stmts = append(stmts, p.statement())
+	}
+	return stmts
+}
+
 func (p *Parser) exprStmt() ast.Stmt {
 	stmt := &ast.ExprStmt{}
 	stmt.Expression = p.expression(LOWEST)
@@ -165,7 +300,8 @@ func (p *Parser) exprStmt() ast.Stmt {
 func (p *Parser) expression(precedence int) ast.Expr {
 	prefixFn := p.mapPrefixFn[p.curToken.Type]
 	if prefixFn == nil {
-		p.newError(fmt.Sprintf("%v no prefix parsing function for this token.", p.curToken))
+		p.newError(fmt.Sprintf("%v no prefix parsing function for this token.", p.curToken.ToString()))
+		p.nextToken() // skip the offending token so the parser keeps moving
 		return nil
 	}
 	leftExpr := prefixFn()
@@ -185,6 +321,12 @@ func (p *Parser) parseLiteral() ast.Expr {
 	return expr
 }
 
+func (p *Parser) parseIdentifier() ast.Expr {
+	expr := &ast.Identifier{Value: p.curToken}
+	p.nextToken()
+	return expr
+}
+
 func (p *Parser) parseGroupedExpr() ast.Expr {
 	p.nextToken()
 	exp := p.expression(LOWEST)
@@ -213,6 +355,43 @@ func (p *Parser) parseInfixExpr(left ast.Expr) ast.Expr {
 	return expr
 }
 
+func (p *Parser) parseCallExpr(callee ast.Expr) ast.Expr {
+	expr := &ast.Call{
+		Callee: callee,
+		Paren:  p.curToken,
+	}
+	p.nextToken() // skip '('
+	if !p.check(token.RPAREN) {
+		for {
+			expr.Arguments = append(expr.Arguments, p.expression(LOWEST))
+			if !p.match(token.COMMA) {
+				break
+			}
+		}
+	}
+	p.expect(token.RPAREN, "expect ')' after arguments.")
+
+	return expr
+}
+
+func (p *Parser) parseAssignExpr(left ast.Expr) ast.Expr {
+	operator := p.curToken
+	p.nextToken()
+	// assignment is right associative: a = b = c is a = (b = c)
+	value := p.expression(ASSIGNMENT - 1)
+
+	target, ok := left.(*ast.Identifier)
+	if !ok {
+		p.newError(fmt.Sprintf("%v invalid assignment target.", operator.ToString()))
+		return value
+	}
+	return &ast.Assign{
+		Name:     target.Value,
+		Operator: operator,
+		Value:    value,
+	}
+}
+
 func (p *Parser) expect(t token.TokenType, msg string) {
 	if p.match(t) {
 		return
@@ -229,6 +408,15 @@ func (p *Parser) match(t token.TokenType) bool {
 	return false
 }
 
+func (p *Parser) check(types ...token.TokenType) bool {
+	for _, t := range types {
+		if p.curToken.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parser) newError(msg string) {
 	p.errors = append(p.errors, msg)
 }
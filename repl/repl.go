@@ -6,8 +6,10 @@ import (
 	"os"
 	"time"
 	"vmlite/ast"
-	"vmlite/compiler"
+	"vmlite/code"
 	"vmlite/lexer"
+	"vmlite/object"
+	"vmlite/optimizer"
 	"vmlite/parser"
 	"vmlite/token"
 	"vmlite/vm"
@@ -32,9 +34,16 @@ const PROMPT = `
 
 const VALUES_SIZE = 65536
 
-var co_consts = []interface{}{}
+var co_consts = []object.Value{}
 var co_names = []string{}
-var co_values = make([]interface{}, VALUES_SIZE)
+var co_values = make([]object.Value, VALUES_SIZE)
+
+var optLevel = optimizer.O2
+
+// SetOptLevel sets the optimizations used from now on, -O2 by default
+func SetOptLevel(level optimizer.Level) {
+	optLevel = level
+}
 
 func Start(mode string, input string) {
 	if mode == "repl" {
@@ -47,6 +56,10 @@ func Start(mode string, input string) {
 		debugCompiler(input)
 	} else if mode == "vm" {
 		debugVM(input)
+	} else if mode == "asm" {
+		runAssembly(input)
+	} else {
+		fmt.Printf("unknown mode '%s', expected one of repl, lexer, parser, compiler, vm, asm\n", mode)
 	}
 }
 
@@ -72,32 +85,23 @@ func repl() {
 }
 
 func run(input string) {
-
-	l := lexer.NewLexer(input)
-	p := parser.NewParser(l)
-	program := p.Program()
-	if len(p.Errors()) > 0 {
-		printErrors(p.Errors())
-	}
-
-	c := compiler.NewCompiler(co_names, co_consts)
-	c.Compile(program)
-	errors := c.Errors()
-	if len(errors) > 0 {
-		printErrors(errors)
+	bc, ok := compile(input)
+	if !ok {
 		return
 	}
-
-	co_codes := c.GetCodes()
-	co_names = c.GetNames()
-	co_consts = c.GetConstants()
+	co_names = bc.Names
+	co_consts = bc.Constants
 
 	// debug
 	//fmt.Printf("co_names[%v]\nco_consts[%v]\n", co_names, co_consts)
 	// debug
 
-	vm := vm.NewVM(co_codes, co_consts, co_names, co_values)
-	err := vm.Run()
+	vm, err := vm.NewVMFromBytecode(bc, co_values)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return
+	}
+	err = vm.Run()
 	if err != nil {
 		fmt.Printf("%s\n", err)
 	}
@@ -110,6 +114,9 @@ func debugLexer(input string) {
 		fmt.Println(tok.ToString())
 		tok = l.NextToken()
 	}
+	if len(l.Errors()) > 0 {
+		printErrors(l.Errors())
+	}
 }
 
 func debugParser(input string) {
@@ -124,58 +131,67 @@ func debugParser(input string) {
 }
 
 func debugCompiler(input string) {
-	l := lexer.NewLexer(input)
-	p := parser.NewParser(l)
-	program := p.Program()
-	if len(p.Errors()) > 0 {
-		printErrors(p.Errors())
-		return
-	}
-	c := compiler.NewCompiler(co_names, co_consts)
-	c.Compile(program)
-	errors := c.Errors()
-	if len(errors) > 0 {
-		printErrors(errors)
+	bc, ok := compile(input)
+	if !ok {
 		return
 	}
+	co_names = bc.Names
+	co_consts = bc.Constants
 
-	co_codes := c.GetCodes()
-	co_names = c.GetNames()
-	co_consts = c.GetConstants()
-
-	output := compiler.PrintByteCode(co_codes, co_consts)
-	fmt.Printf("%v\n", output)
+	fmt.Printf("%v\n", code.Disassemble(bc))
 }
 
 func debugVM(input string) {
-	l := lexer.NewLexer(input)
-	p := parser.NewParser(l)
-	program := p.Program()
-	if len(p.Errors()) > 0 {
-		printErrors(p.Errors())
+	bc, ok := compile(input)
+	if !ok {
 		return
 	}
-	c := compiler.NewCompiler(co_names, co_consts)
-	c.Compile(program)
-	errors := c.Errors()
+	co_names = bc.Names
+	co_consts = bc.Constants
+
+	vm, err := vm.NewVMFromBytecode(bc, co_values)
+	if err != nil {
+		panic(err)
+	}
+	err = vm.Run()
+	if err != nil {
+		panic(err)
+	}
+	last := vm.LastPopped()
+	if last.Kind != object.NilKind {
+		fmt.Printf("%v\n", last)
+	}
+}
+
+// runAssembly assembles the input and runs it, the bytecode is listed
+// first so the result can be compared with the source
+func runAssembly(input string) {
+	bc, errors := code.Assemble(input)
 	if len(errors) > 0 {
 		printErrors(errors)
 		return
 	}
+	fmt.Printf("%v\n", code.Disassemble(bc))
 
-	co_codes := c.GetCodes()
-	co_names = c.GetNames()
-	co_consts = c.GetConstants()
-
-	vm := vm.NewVM(co_codes, co_consts, co_names, co_values)
-	err := vm.Run()
+	vm, err := vm.NewVMFromBytecode(bc, make([]object.Value, VALUES_SIZE))
 	if err != nil {
-		panic(err)
+		fmt.Printf("%s\n", err)
+		return
 	}
-	tos := vm.TOS()
-	if tos != nil {
-		fmt.Printf("%v\n", tos)
+	if err := vm.Run(); err != nil {
+		fmt.Printf("%s\n", err)
+	}
+}
+
+// compiles a line at the current level on top of the previous ones, the
+// errors are printed
+func compile(input string) (*code.Bytecode, bool) {
+	bc, errors := optimizer.Build(input, co_names, co_consts, optLevel)
+	if len(errors) > 0 {
+		printErrors(errors)
+		return nil, false
 	}
+	return bc, true
 }
 
 func printErrors(errors []string) {
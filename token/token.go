@@ -14,9 +14,16 @@ const (
 	MINUS
 	MUL
 	DIV
+	IDIV
+	MOD
 	LPAREN
 	RPAREN
+	COMMA
 	ASSIGN
+	PLUS_ASSIGN
+	MINUS_ASSIGN
+	MUL_ASSIGN
+	DIV_ASSIGN
 
 	// comparison
 	LT
@@ -34,6 +41,21 @@ const (
 	FALSE
 	AND
 	OR
+	IF
+	ELSE
+	ENDIF
+	WHILE
+	ENDWHILE
+	FOR
+	TO
+	STEP
+	ENDFOR
+	BREAK
+	CONTINUE
+	DO
+	END
+	FUNC
+	RETURN
 	EOF
 )
 
@@ -45,9 +67,16 @@ var tokenNames = []string{
 	"MINUS",
 	"MUL",
 	"DIV",
+	"IDIV",
+	"MOD",
 	"LPAREN",
 	"RPAREN",
+	"COMMA",
 	"ASSIGN",
+	"PLUS_ASSIGN",
+	"MINUS_ASSIGN",
+	"MUL_ASSIGN",
+	"DIV_ASSIGN",
 	"LT",
 	"GT",
 	"LEQ",
@@ -61,6 +90,21 @@ var tokenNames = []string{
 	"FALSE",
 	"AND",
 	"OR",
+	"IF",
+	"ELSE",
+	"ENDIF",
+	"WHILE",
+	"ENDWHILE",
+	"FOR",
+	"TO",
+	"STEP",
+	"ENDFOR",
+	"BREAK",
+	"CONTINUE",
+	"DO",
+	"END",
+	"FUNC",
+	"RETURN",
 	"EOF",
 }
 
@@ -69,9 +113,16 @@ var symbolMap = map[string]TokenType{
 	"-":  MINUS,
 	"*":  MUL,
 	"/":  DIV,
+	"//": IDIV,
+	"%":  MOD,
 	"(":  LPAREN,
 	")":  RPAREN,
+	",":  COMMA,
 	"=":  ASSIGN,
+	"+=": PLUS_ASSIGN,
+	"-=": MINUS_ASSIGN,
+	"*=": MUL_ASSIGN,
+	"/=": DIV_ASSIGN,
 	"<":  LT,
 	">":  GT,
 	"<=": LEQ,
@@ -82,12 +133,30 @@ var symbolMap = map[string]TokenType{
 }
 
 var keywords = map[string]TokenType{
-	"var":   VAR,
-	"print": PRINT,
-	"true":  TRUE,
-	"false": FALSE,
-	"and":   AND,
-	"or":    OR,
+	"var":      VAR,
+	"print":    PRINT,
+	"true":     TRUE,
+	"false":    FALSE,
+	"and":      AND,
+	"or":       OR,
+	"if":       IF,
+	"else":     ELSE,
+	"endif":    ENDIF,
+	"while":    WHILE,
+	"endwhile": ENDWHILE,
+	"for":      FOR,
+	"to":       TO,
+	"step":     STEP,
+	"endfor":   ENDFOR,
+	// FoxPro spells break/continue as exit/loop
+	"break":    BREAK,
+	"exit":     BREAK,
+	"continue": CONTINUE,
+	"loop":     CONTINUE,
+	"do":       DO,
+	"end":      END,
+	"func":     FUNC,
+	"return":   RETURN,
 }
 
 type Token struct {
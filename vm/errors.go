@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"vmlite/code"
+	"vmlite/object"
+)
+
+// TypeError is returned when an operator gets operands it cannot work with
+type TypeError struct {
+	Op       code.Opcode
+	Operands []object.Value
+}
+
+func (e *TypeError) Error() string {
+	types := []string{}
+	for _, v := range e.Operands {
+		types = append(types, object.TypeName(v))
+	}
+	if len(types) == 1 {
+		return fmt.Sprintf("unsupported operand type for %s: %s", code.CodeMap[e.Op], types[0])
+	}
+	return fmt.Sprintf("unsupported operand types for %s: %s", code.CodeMap[e.Op], strings.Join(types, " and "))
+}
+
+// calls shown by RuntimeError.Error, deep recursions are cut
+const MAX_TRACE = 10
+
+var ErrStackOverflow = fmt.Errorf("stack overflow")
+var ErrStackUnderflow = fmt.Errorf("stack underflow")
+
+// vmPanic carries a stack error from deep inside the VM up to Run
+type vmPanic struct {
+	err error
+}
+
+// RuntimeError is what Run returns when an instruction fails, Err is the
+// cause (a *TypeError, a division by zero, ...)
+type RuntimeError struct {
+	Err   error
+	Op    code.Opcode
+	IP    int    // offset of the failing instruction in its function
+	Ln    int    // source position, 0 when unknown
+	Col   int    //
+	Trace []Call // innermost call first
+}
+
+// Call is an active function when the error happened
+type Call struct {
+	Function string
+	IP       int // the failing instruction or the pending CALL
+	Ln       int
+	Col      int
+}
+
+func (e *RuntimeError) Error() string {
+	var out strings.Builder
+	out.WriteString(e.Err.Error())
+	if e.Ln > 0 {
+		out.WriteString(fmt.Sprintf(" at Ln: %d, Col: %d", e.Ln, e.Col))
+	}
+	name, ok := code.CodeMap[e.Op]
+	if !ok {
+		name = fmt.Sprintf("opcode %d", e.Op)
+	}
+	out.WriteString(fmt.Sprintf(" (%s at ip %04d)", name, e.IP))
+	for i, c := range e.Trace {
+		if i == MAX_TRACE {
+			out.WriteString(fmt.Sprintf("\n  ... %d more calls", len(e.Trace)-i))
+			break
+		}
+		out.WriteString(fmt.Sprintf("\n  in %s, ip %04d", c.Function, c.IP))
+		if c.Ln > 0 {
+			out.WriteString(fmt.Sprintf(", Ln: %d, Col: %d", c.Ln, c.Col))
+		}
+	}
+	return out.String()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,20 @@
+package vm
+
+import "vmlite/object"
+
+const MAX_FRAMES = 1024
+
+// Frame is the activation record of a running function
+type Frame struct {
+	cl *object.Closure
+	ip int // next instruction inside cl.Fn
+	bp int // base pointer: stack slot of the first parameter/local
+}
+
+func NewFrame(cl *object.Closure, bp int) *Frame {
+	return &Frame{cl: cl, ip: 0, bp: bp}
+}
+
+func (f *Frame) Instructions() []byte {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,123 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"vmlite/code"
+	"vmlite/object"
+)
+
+// int64 arithmetic never wraps around, an overflow is a runtime error
+func intArithmetic(op code.Opcode, l int64, r int64) (int64, error) {
+	var v int64
+	overflow := false
+	switch op {
+	case code.ADDI:
+		v = l + r
+		overflow = (r > 0 && v < l) || (r < 0 && v > l)
+	case code.SUBI:
+		v = l - r
+		overflow = (r > 0 && v > l) || (r < 0 && v < l)
+	case code.MULI:
+		v = l * r
+		overflow = l != 0 && (v/l != r || (l == -1 && r == math.MinInt64))
+	case code.IDIVI, code.MODI:
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if l == math.MinInt64 && r == -1 {
+			if op == code.MODI {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("integer overflow")
+		}
+		// floored like FoxPro's MOD(): the remainder has the sign of r
+		q, m := l/r, l%r
+		if m != 0 && (m < 0) != (r < 0) {
+			q -= 1
+			m += r
+		}
+		if op == code.IDIVI {
+			return q, nil
+		}
+		return m, nil
+	}
+	if overflow {
+		return 0, fmt.Errorf("integer overflow")
+	}
+	return v, nil
+}
+
+// decimal arithmetic is exact, products and quotients are rounded with the
+// current rounding mode
+func decimalArithmetic(op code.Opcode, l object.Decimal, r object.Decimal) (object.Decimal, error) {
+	switch op {
+	case code.ADDD:
+		return l.Add(r)
+	case code.SUBD:
+		return l.Sub(r)
+	case code.MULD:
+		return l.Mul(r, object.DecimalRounding)
+	case code.DIVD:
+		return l.Div(r, object.DecimalRounding)
+	case code.IDIVD:
+		// both are scaled the same way, the quotient of the raw values is
+		// already the integer part
+		q, err := intArithmetic(code.IDIVI, int64(l), int64(r))
+		if err != nil {
+			return 0, err
+		}
+		return object.DecimalFromInt(q)
+	case code.MODD:
+		m, err := intArithmetic(code.MODI, int64(l), int64(r))
+		return object.Decimal(m), err
+	}
+	return 0, fmt.Errorf("unknown decimal operator %s", code.CodeMap[op])
+}
+
+// the specialized opcodes of each numeric kind
+var numberOps = map[object.ValueKind]map[code.Opcode]code.Opcode{
+	object.IntKind:     intOps,
+	object.DecimalKind: decimalOps,
+	object.FloatKind:   floatOps,
+}
+
+// converts both operands on top of the stack to the given kind, numbers are
+// promoted along int -> decimal -> float
+func (vm *VM) promote(kind object.ValueKind) error {
+	for i := vm.sp - 2; i < vm.sp; i++ {
+		v := vm.stack[i]
+		switch {
+		case v.Kind == object.IntKind && kind == object.DecimalKind:
+			d, err := object.DecimalFromInt(v.AsInt())
+			if err != nil {
+				return err
+			}
+			vm.stack[i] = object.DecimalValue(d)
+		case v.Kind == object.IntKind && kind == object.FloatKind:
+			vm.stack[i] = object.FloatValue(float64(v.AsInt()))
+		case v.Kind == object.DecimalKind && kind == object.FloatKind:
+			vm.stack[i] = object.FloatValue(v.AsDecimal().Float())
+		}
+	}
+	return nil
+}
+
+// Apply runs a unary or a binary operator on constant operands outside of
+// any program. The optimizer folds constant expressions with it, so a
+// folded expression gives exactly what the VM would.
+func Apply(op code.Opcode, operands ...object.Value) (object.Value, error) {
+	// a frame at the bottom of the stack, operators pop from it
+	vm := &VM{stack: make([]object.Value, len(operands)), frames: []*Frame{{}}, fp: 1}
+	vm.sp = copy(vm.stack, operands)
+	var err error
+	if len(operands) == 1 {
+		err = vm.unary(op)
+	} else {
+		err = vm.binary(op)
+	}
+	if err != nil {
+		return object.Nil, err
+	}
+	return vm.stack[0], nil
+}
@@ -1,214 +1,879 @@
 package vm
 
 import (
-	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"strings"
 	"vmlite/code"
+	"vmlite/object"
 )
 
 const STACK_SIZE = 2048
 
-type OpCodeFn func() error
-
 type VM struct {
-	co_codes  []code.Opcode
-	co_consts []interface{}
+	co_consts []object.Value
 	co_names  []string
-	co_values []interface{}
-	stack     []interface{}
+	co_values []object.Value
+	stack     []object.Value
 	sp        int
-	ip        int
-	mapCode   map[code.Opcode]OpCodeFn
+	frames    []*Frame
+	fp        int               // number of active frames
+	upvalues  []*object.Upvalue // open upvalues, still pointing into the stack
+	popped    object.Value      // last value discarded by POP_RESULT
+	out       io.Writer         // where PRINT writes
 }
 
-func NewVM(co_codes []code.Opcode, co_consts []interface{}, co_names []string, co_values []interface{}) *VM {
+// the operand widths of every opcode, from the definitions of package code
+var operandWidths [256][]int
+
+func init() {
+	for op := 0; op < len(operandWidths); op++ {
+		if def, err := code.Lookup(code.Opcode(op)); err == nil {
+			operandWidths[op] = def.OperandWidths
+		}
+	}
+}
+
+func NewVM(co_codes []code.Opcode, co_consts []object.Value, co_names []string, co_values []object.Value) *VM {
+	// the top-level code runs as the body of an implicit main function
+	main := &object.CompiledFunction{Name: "main", Instructions: co_codes}
 	vm := &VM{
-		co_codes:  co_codes,
 		co_consts: co_consts,
 		co_names:  co_names,
 		co_values: co_values,
-		stack:     make([]interface{}, STACK_SIZE),
+		stack:     make([]object.Value, STACK_SIZE),
 		sp:        0,
-		mapCode:   make(map[byte]OpCodeFn),
-	}
-	// register semantic opcode
-	vm.mapCode[code.PUSHF] = vm.OpPushFloatFn
-	vm.mapCode[code.PUSHS] = vm.OpPushStringFn
-	vm.mapCode[code.BOOL] = vm.OpBoolFn
-	vm.mapCode[code.CMP] = vm.OpBinaryFn
-	vm.mapCode[code.UNARY] = vm.OpUnaryFn
-	vm.mapCode[code.STORE] = vm.OpStoreFn
-	vm.mapCode[code.LOAD] = vm.OpLoadFn
-	vm.mapCode[code.PRINT] = vm.OpPrintFn
+		frames:    make([]*Frame, MAX_FRAMES),
+		out:       os.Stdout,
+	}
+	vm.pushFrame(NewFrame(&object.Closure{Fn: main}, 0))
 	return vm
 }
 
-func (vm *VM) push(v interface{}) {
+// NewVMFromBytecode refuses bytecode compiled for another encoding and
+// bytecode that does not pass code.Verify
+func NewVMFromBytecode(bc *code.Bytecode, co_values []object.Value) (*VM, error) {
+	if bc.Version != code.VERSION {
+		return nil, fmt.Errorf("bytecode version %d is not supported, expected version %d", bc.Version, code.VERSION)
+	}
+	if len(bc.Names) > len(co_values) {
+		return nil, fmt.Errorf("the program has %d globals, the VM can hold %d", len(bc.Names), len(co_values))
+	}
+	if err := code.Verify(bc); err != nil {
+		return nil, err
+	}
+	vm := NewVM(bc.Instructions, bc.Constants, bc.Names, co_values)
+	vm.frames[0].cl.Fn.Lines = bc.Lines
+	return vm, nil
+}
+
+// SetOutput makes PRINT write to w instead of the standard output
+func (vm *VM) SetOutput(w io.Writer) {
+	vm.out = w
+}
+
+func (vm *VM) push(v object.Value) {
+	if vm.sp >= len(vm.stack) {
+		panic(vmPanic{ErrStackOverflow}) // recovered by Run
+	}
 	vm.stack[vm.sp] = v
 	vm.sp += 1
 }
 
-func (vm *VM) pop() interface{} {
+func (vm *VM) pop() object.Value {
+	vm.need(1)
 	v := vm.stack[vm.sp-1]
 	vm.sp -= 1
 	return v
 }
 
-func (vm *VM) TOS() interface{} {
-	if vm.sp > 0 {
-		return vm.stack[vm.sp-1]
-	}
-	return nil
+// LastPopped returns the value most recently discarded by a POP_RESULT
+// instruction, that is the result of the last top-level expression
+// statement that is not an assignment.
+func (vm *VM) LastPopped() object.Value {
+	return vm.popped
 }
 
-func (vm *VM) Run() error {
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.fp-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.fp] = f
+	vm.fp += 1
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.fp -= 1
+	return vm.frames[vm.fp]
+}
+
+// Run dispatches with a switch, the Go compiler turns the dense opcode
+// values into a jump table. Every failure is returned as a *RuntimeError,
+// stack overflows included. Any other panic is a bug in the VM and is not
+// recovered.
+func (vm *VM) Run() (err error) {
+	var f *Frame
+	var ip int
+	var op code.Opcode
+	defer func() {
+		if r := recover(); r != nil {
+			p, ok := r.(vmPanic)
+			if !ok {
+				panic(r)
+			}
+			err = vm.runtimeError(p.err, f, ip, op)
+		}
+	}()
+
+	for {
+		f = vm.currentFrame()
+		ins := f.Instructions()
+		if f.ip >= len(ins) {
+			break // end of the main code, functions always RETURN
+		}
+		ip = f.ip
+		op = ins[f.ip]
+		f.ip += 1
 
-	for vm.ip < len(vm.co_codes) {
-		op := vm.co_codes[vm.ip]
-		vm.ip += 1
-		opFn := vm.mapCode[op]
-		if opFn == nil {
-			return fmt.Errorf("unknown opcode: <%v, %v>", op, code.CodeMap[op])
+		switch op {
+		case code.PUSHF:
+			err = vm.OpPushFloatFn()
+		case code.PUSHI:
+			err = vm.OpPushIntFn()
+		case code.PUSHD:
+			err = vm.OpPushDecimalFn()
+		case code.PUSHS:
+			err = vm.OpPushStringFn()
+		case code.TRUE:
+			err = vm.OpTrueFn()
+		case code.FALSE:
+			err = vm.OpFalseFn()
+		case code.ADDS, code.SUBS,
+			code.ADDI, code.SUBI, code.MULI, code.IDIVI, code.MODI,
+			code.ADDF, code.SUBF, code.MULF, code.DIVF, code.IDIVF, code.MODF,
+			code.ADDD, code.SUBD, code.MULD, code.DIVD, code.IDIVD, code.MODD,
+			code.EQI, code.NEQI, code.LTI, code.LEQI, code.GTI, code.GEQI,
+			code.EQD, code.NEQD, code.LTD, code.LEQD, code.GTD, code.GEQD,
+			code.EQF, code.NEQF, code.LTF, code.LEQF, code.GTF, code.GEQF,
+			code.AND, code.OR,
+			code.ADD, code.SUB, code.MUL, code.DIV, code.IDIV, code.MOD,
+			code.EQ, code.NEQ, code.LT, code.LEQ, code.GT, code.GEQ:
+			err = vm.binary(op)
+		case code.NEGI, code.NEGD, code.NEGF, code.NOT, code.NEG:
+			err = vm.unary(op)
+		case code.STORE:
+			err = vm.OpStoreFn()
+		case code.LOAD:
+			err = vm.OpLoadFn()
+		case code.PRINT:
+			err = vm.OpPrintFn()
+		case code.JUMP:
+			err = vm.OpJumpFn()
+		case code.JUMPF:
+			err = vm.OpJumpFalseFn()
+		case code.POP:
+			err = vm.OpPopFn()
+		case code.POP_RESULT:
+			err = vm.OpPopResultFn()
+		case code.DUP:
+			err = vm.OpDupFn()
+		case code.LOAD_LOCAL:
+			err = vm.OpLoadLocalFn()
+		case code.STORE_LOCAL:
+			err = vm.OpStoreLocalFn()
+		case code.NIL:
+			err = vm.OpNilFn()
+		case code.CLOSURE:
+			err = vm.OpClosureFn()
+		case code.CALL:
+			err = vm.OpCallFn()
+		case code.RETURN:
+			err = vm.OpReturnFn()
+		case code.LOAD_BUILTIN:
+			err = vm.OpLoadBuiltinFn()
+		case code.LOAD_UPVALUE:
+			err = vm.OpLoadUpvalueFn()
+		case code.STORE_UPVALUE:
+			err = vm.OpStoreUpvalueFn()
+		case code.CLOSE_UPVALUE:
+			err = vm.OpCloseUpvalueFn()
+		case code.LOAD_LOCAL2:
+			err = vm.OpLoadLocal2Fn()
+		case code.LOAD_LOCAL_CONST:
+			err = vm.OpLoadLocalConstFn()
+		case code.LOAD_CONST:
+			err = vm.OpLoadConstFn()
+		case code.LOAD_LOCAL_CONST_OP:
+			err = vm.OpLoadLocalConstOpFn()
+		case code.LOAD_CONST_OP:
+			err = vm.OpLoadConstOpFn()
+		default:
+			err = fmt.Errorf("unknown opcode: %d", op)
 		}
-		err := opFn()
 		if err != nil {
-			return err
+			return vm.runtimeError(err, f, ip, op)
 		}
 	}
 	return nil
 }
 
 func (vm *VM) OpPushStringFn() error {
-	i := binary.BigEndian.Uint32(vm.co_codes[vm.ip:])
-	vm.ip += 4
+	i := vm.readOperand(code.PUSHS, 0)
 	v := vm.co_consts[i]
 	vm.push(v)
 	return nil
 }
 
 func (vm *VM) OpPushFloatFn() error {
-	i := binary.BigEndian.Uint32(vm.co_codes[vm.ip:])
-	vm.ip += 4
-	vm.push(float32(i))
+	i := vm.readOperand(code.PUSHF, 0)
+	vm.push(vm.co_consts[i])
 	return nil
 }
 
-func (vm *VM) OpBoolFn() error {
-	op := vm.co_codes[vm.ip]
-	vm.ip += 1 // advance the instruction pointer
-	if op == code.TRUE {
-		vm.push(true)
-	} else {
-		vm.push(false)
-	}
+func (vm *VM) OpPushIntFn() error {
+	i := vm.readOperand(code.PUSHI, 0)
+	vm.push(vm.co_consts[i])
 	return nil
 }
 
-func (vm *VM) OpBinaryFn() error {
+func (vm *VM) OpPushDecimalFn() error {
+	i := vm.readOperand(code.PUSHD, 0)
+	vm.push(vm.co_consts[i])
+	return nil
+}
 
-	// get the comparison operator
-	op := vm.co_codes[vm.ip]
-	vm.ip += 1 // dont forget advance the instruction pointer
+func (vm *VM) OpTrueFn() error {
+	vm.push(object.BoolValue(true))
+	return nil
+}
+
+func (vm *VM) OpFalseFn() error {
+	vm.push(object.BoolValue(false))
+	return nil
+}
+
+func (vm *VM) binary(op code.Opcode) error {
+	if err := vm.checkOperands(op, 2); err != nil {
+		return err
+	}
 
 	switch op {
 	case code.ADDS:
 		r, l := vm.popString()
-		vm.push(l + r)
+		vm.push(object.StringValue(l + r))
 	case code.SUBS:
 		r, l := vm.popString()
-		vm.push(strings.TrimRight(l, " ") + r)
-	case code.ADD:
+		vm.push(object.StringValue(strings.TrimRight(l, " ") + r))
+	case code.ADDI, code.SUBI, code.MULI, code.IDIVI, code.MODI:
+		r, l := vm.popInt()
+		v, err := intArithmetic(op, l, r)
+		if err != nil {
+			return err
+		}
+		vm.push(object.IntValue(v))
+	case code.LTI:
+		r, l := vm.popInt()
+		vm.push(object.BoolValue(l < r))
+	case code.LEQI:
+		r, l := vm.popInt()
+		vm.push(object.BoolValue(l <= r))
+	case code.GTI:
+		r, l := vm.popInt()
+		vm.push(object.BoolValue(l > r))
+	case code.GEQI:
+		r, l := vm.popInt()
+		vm.push(object.BoolValue(l >= r))
+	case code.EQI:
+		r, l := vm.popInt()
+		vm.push(object.BoolValue(l == r))
+	case code.NEQI:
+		r, l := vm.popInt()
+		vm.push(object.BoolValue(l != r))
+	case code.ADDD, code.SUBD, code.MULD, code.DIVD, code.IDIVD, code.MODD:
+		r, l := vm.popDecimal()
+		v, err := decimalArithmetic(op, l, r)
+		if err != nil {
+			return err
+		}
+		vm.push(object.DecimalValue(v))
+	case code.LTD:
+		r, l := vm.popDecimal()
+		vm.push(object.BoolValue(l < r))
+	case code.LEQD:
+		r, l := vm.popDecimal()
+		vm.push(object.BoolValue(l <= r))
+	case code.GTD:
+		r, l := vm.popDecimal()
+		vm.push(object.BoolValue(l > r))
+	case code.GEQD:
+		r, l := vm.popDecimal()
+		vm.push(object.BoolValue(l >= r))
+	case code.EQD:
+		r, l := vm.popDecimal()
+		vm.push(object.BoolValue(l == r))
+	case code.NEQD:
+		r, l := vm.popDecimal()
+		vm.push(object.BoolValue(l != r))
+	case code.ADDF:
 		r, l := vm.popFloat()
-		vm.push(l + r)
-	case code.SUB:
+		vm.push(object.FloatValue(l + r))
+	case code.SUBF:
 		r, l := vm.popFloat()
-		vm.push(l - r)
-	case code.MUL:
+		vm.push(object.FloatValue(l - r))
+	case code.MULF:
 		r, l := vm.popFloat()
-		vm.push(l * r)
-	case code.DIV:
+		vm.push(object.FloatValue(l * r))
+	case code.DIVF:
 		r, l := vm.popFloat()
 		if r == 0 {
 			return fmt.Errorf("division by zero")
 		}
-		vm.push(l / r)
-	case code.LT:
+		vm.push(object.FloatValue(l / r))
+	case code.IDIVF:
 		r, l := vm.popFloat()
-		vm.push(l < r)
-	case code.LEQ:
+		if r == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		vm.push(object.FloatValue(math.Floor(l / r)))
+	case code.MODF:
 		r, l := vm.popFloat()
-		vm.push(l <= r)
-	case code.GT:
+		if r == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		vm.push(object.FloatValue(l - r*math.Floor(l/r)))
+	case code.LTF:
 		r, l := vm.popFloat()
-		vm.push(l > r)
-	case code.GEQ:
+		vm.push(object.BoolValue(l < r))
+	case code.LEQF:
+		r, l := vm.popFloat()
+		vm.push(object.BoolValue(l <= r))
+	case code.GTF:
+		r, l := vm.popFloat()
+		vm.push(object.BoolValue(l > r))
+	case code.GEQF:
 		r, l := vm.popFloat()
-		vm.push(l >= r)
-	case code.EQ:
+		vm.push(object.BoolValue(l >= r))
+	case code.EQF:
 		r, l := vm.popFloat()
-		vm.push(l == r)
-	case code.NEQ:
+		vm.push(object.BoolValue(l == r))
+	case code.NEQF:
 		r, l := vm.popFloat()
-		vm.push(l != r)
+		vm.push(object.BoolValue(l != r))
 	case code.AND:
 		r, l := vm.popBoolean()
-		vm.push(l && r)
+		vm.push(object.BoolValue(l && r))
 	case code.OR:
 		r, l := vm.popBoolean()
-		vm.push(l || r)
+		vm.push(object.BoolValue(l || r))
+	default:
+		return vm.dynamicBinary(op)
 	}
 	return nil
 }
 
+// the operand kind each specialized opcode was compiled for, checked
+// before running so bad bytecode cannot crash the VM
+var operandKinds = [256]object.ValueKind{
+	code.ADDS:  object.StringKind,
+	code.SUBS:  object.StringKind,
+	code.ADDI:  object.IntKind,
+	code.SUBI:  object.IntKind,
+	code.MULI:  object.IntKind,
+	code.IDIVI: object.IntKind,
+	code.MODI:  object.IntKind,
+	code.NEGI:  object.IntKind,
+	code.LTI:   object.IntKind,
+	code.LEQI:  object.IntKind,
+	code.GTI:   object.IntKind,
+	code.GEQI:  object.IntKind,
+	code.EQI:   object.IntKind,
+	code.NEQI:  object.IntKind,
+	code.ADDD:  object.DecimalKind,
+	code.SUBD:  object.DecimalKind,
+	code.MULD:  object.DecimalKind,
+	code.DIVD:  object.DecimalKind,
+	code.IDIVD: object.DecimalKind,
+	code.MODD:  object.DecimalKind,
+	code.NEGD:  object.DecimalKind,
+	code.LTD:   object.DecimalKind,
+	code.LEQD:  object.DecimalKind,
+	code.GTD:   object.DecimalKind,
+	code.GEQD:  object.DecimalKind,
+	code.EQD:   object.DecimalKind,
+	code.NEQD:  object.DecimalKind,
+	code.ADDF:  object.FloatKind,
+	code.SUBF:  object.FloatKind,
+	code.MULF:  object.FloatKind,
+	code.DIVF:  object.FloatKind,
+	code.IDIVF: object.FloatKind,
+	code.MODF:  object.FloatKind,
+	code.NEGF:  object.FloatKind,
+	code.LTF:   object.FloatKind,
+	code.LEQF:  object.FloatKind,
+	code.GTF:   object.FloatKind,
+	code.GEQF:  object.FloatKind,
+	code.EQF:   object.FloatKind,
+	code.NEQF:  object.FloatKind,
+	code.AND:   object.BoolKind,
+	code.OR:    object.BoolKind,
+	code.NOT:   object.BoolKind,
+}
+
+// the specialized opcode each generic one becomes for int64 operands, '/'
+// is missing as it always divides floats
+var intOps = map[code.Opcode]code.Opcode{
+	code.ADD:  code.ADDI,
+	code.SUB:  code.SUBI,
+	code.MUL:  code.MULI,
+	code.IDIV: code.IDIVI,
+	code.MOD:  code.MODI,
+	code.LT:   code.LTI,
+	code.LEQ:  code.LEQI,
+	code.GT:   code.GTI,
+	code.GEQ:  code.GEQI,
+	code.EQ:   code.EQI,
+	code.NEQ:  code.NEQI,
+	code.NEG:  code.NEGI,
+}
+
+// ... for decimal operands
+var decimalOps = map[code.Opcode]code.Opcode{
+	code.ADD:  code.ADDD,
+	code.SUB:  code.SUBD,
+	code.MUL:  code.MULD,
+	code.DIV:  code.DIVD,
+	code.IDIV: code.IDIVD,
+	code.MOD:  code.MODD,
+	code.LT:   code.LTD,
+	code.LEQ:  code.LEQD,
+	code.GT:   code.GTD,
+	code.GEQ:  code.GEQD,
+	code.EQ:   code.EQD,
+	code.NEQ:  code.NEQD,
+	code.NEG:  code.NEGD,
+}
+
+// ... for float64 operands
+var floatOps = map[code.Opcode]code.Opcode{
+	code.ADD:  code.ADDF,
+	code.SUB:  code.SUBF,
+	code.MUL:  code.MULF,
+	code.DIV:  code.DIVF,
+	code.IDIV: code.IDIVF,
+	code.MOD:  code.MODF,
+	code.LT:   code.LTF,
+	code.LEQ:  code.LEQF,
+	code.GT:   code.GTF,
+	code.GEQ:  code.GEQF,
+	code.EQ:   code.EQF,
+	code.NEQ:  code.NEQF,
+	code.NEG:  code.NEGF,
+}
+
+// ... and for string operands
+var stringOps = map[code.Opcode]code.Opcode{
+	code.ADD: code.ADDS,
+	code.SUB: code.SUBS,
+}
+
+// runs a generic operator on the runtime type of the operands, which are
+// still on the stack
+func (vm *VM) dynamicBinary(op code.Opcode) error {
+	r := vm.stack[vm.sp-1]
+	l := vm.stack[vm.sp-2]
+	if l.IsNumber() && r.IsNumber() {
+		kind := l.Kind
+		if r.Kind > kind {
+			kind = r.Kind
+		}
+		if op == code.DIV && kind == object.IntKind {
+			kind = object.FloatKind // '/' never truncates
+		}
+		if err := vm.promote(kind); err != nil {
+			return err
+		}
+		if typed, ok := numberOps[kind][op]; ok {
+			return vm.binary(typed)
+		}
+		return &TypeError{Op: op, Operands: []object.Value{l, r}}
+	}
+	if op == code.EQ || op == code.NEQ {
+		// any two values can be compared, different types are never equal
+		vm.sp -= 2
+		vm.push(object.BoolValue(l.Equals(r) == (op == code.EQ)))
+		return nil
+	}
+
+	if l.Kind == object.StringKind && r.Kind == object.StringKind {
+		if typed, ok := stringOps[op]; ok {
+			return vm.binary(typed)
+		}
+		if v, ok := compareStrings(op, l.AsString(), r.AsString()); ok {
+			vm.sp -= 2
+			vm.push(object.BoolValue(v))
+			return nil
+		}
+	}
+	return &TypeError{Op: op, Operands: []object.Value{l, r}}
+}
+
+// orders two strings lexicographically
+func compareStrings(op code.Opcode, l string, r string) (bool, bool) {
+	switch op {
+	case code.LT:
+		return l < r, true
+	case code.LEQ:
+		return l <= r, true
+	case code.GT:
+		return l > r, true
+	case code.GEQ:
+		return l >= r, true
+	}
+	return false, false
+}
+
 func (vm *VM) OpStoreFn() error {
-	i := binary.BigEndian.Uint32(vm.co_codes[vm.ip:])
-	vm.ip += 4
+	i := vm.readOperand(code.STORE, 0)
 	v := vm.pop()
 	vm.co_values[i] = v
 	return nil
 }
 
 func (vm *VM) OpLoadFn() error {
-	i := binary.BigEndian.Uint32(vm.co_codes[vm.ip:])
-	vm.ip += 4
+	i := vm.readOperand(code.LOAD, 0)
 	v := vm.co_values[i]
 	vm.push(v)
 	return nil
 }
 
-func (vm *VM) OpUnaryFn() error {
-	op := vm.co_codes[vm.ip]
-	vm.ip += 1 // advance the ip
+func (vm *VM) unary(op code.Opcode) error {
+	vm.need(1)
+	if op == code.NEG {
+		v := vm.stack[vm.sp-1]
+		if !v.IsNumber() {
+			return &TypeError{Op: op, Operands: []object.Value{v}}
+		}
+		op = numberOps[v.Kind][op]
+	}
+	if err := vm.checkOperands(op, 1); err != nil {
+		return err
+	}
 
-	if op == code.UNEG {
-		v := vm.pop().(float32)
-		vm.push(-v)
-	} else {
-		v := vm.pop().(bool)
-		if v {
-			vm.push(false)
-		} else {
-			vm.push(true)
+	switch op {
+	case code.NEGI:
+		v := vm.pop().AsInt()
+		if v == math.MinInt64 {
+			return fmt.Errorf("integer overflow")
+		}
+		vm.push(object.IntValue(-v))
+	case code.NEGD:
+		v, err := decimalArithmetic(code.SUBD, 0, vm.pop().AsDecimal())
+		if err != nil {
+			return err
 		}
+		vm.push(object.DecimalValue(v))
+	case code.NEGF:
+		vm.push(object.FloatValue(-vm.pop().AsFloat()))
+	default:
+		vm.push(object.BoolValue(!vm.pop().AsBool()))
 	}
 	return nil
 }
 
 func (vm *VM) OpPrintFn() error {
-	fmt.Printf("%v\n", vm.pop())
+	fmt.Fprintf(vm.out, "%v\n", vm.pop().String())
+	return nil
+}
+
+func (vm *VM) OpJumpFn() error {
+	i := vm.readOperand(code.JUMP, 0)
+	vm.currentFrame().ip = i
+	return nil
+}
+
+func (vm *VM) OpJumpFalseFn() error {
+	i := vm.readOperand(code.JUMPF, 0)
+	v := vm.pop()
+	if v.Kind != object.BoolKind {
+		return fmt.Errorf("condition must be a boolean value")
+	}
+	if !v.AsBool() {
+		vm.currentFrame().ip = i
+	}
+	return nil
+}
+
+func (vm *VM) OpPopFn() error {
+	vm.pop()
+	return nil
+}
+
+func (vm *VM) OpPopResultFn() error {
+	vm.popped = vm.pop()
+	return nil
+}
+
+func (vm *VM) OpDupFn() error {
+	vm.need(1)
+	vm.push(vm.stack[vm.sp-1])
+	return nil
+}
+
+func (vm *VM) OpLoadLocalFn() error {
+	i := vm.readOperand(code.LOAD_LOCAL, 0)
+	vm.push(vm.stack[vm.currentFrame().bp+i])
+	return nil
+}
+
+func (vm *VM) OpStoreLocalFn() error {
+	i := vm.readOperand(code.STORE_LOCAL, 0)
+	vm.stack[vm.currentFrame().bp+i] = vm.pop()
+	return nil
+}
+
+func (vm *VM) OpNilFn() error {
+	vm.push(object.Nil)
+	return nil
+}
+
+func (vm *VM) OpClosureFn() error {
+	i := vm.readOperand(code.CLOSURE, 0)
+	fn := vm.co_consts[i].AsObject().(*object.CompiledFunction)
+	cl := &object.Closure{Fn: fn, Upvalues: make([]*object.Upvalue, len(fn.Upvalues))}
+	vm.push(object.ObjectValue(cl))
+
+	f := vm.currentFrame()
+	for j, u := range fn.Upvalues {
+		if u.IsLocal {
+			cl.Upvalues[j] = vm.captureUpvalue(f.bp + u.Index)
+		} else {
+			cl.Upvalues[j] = f.cl.Upvalues[u.Index]
+		}
+	}
+	return nil
+}
+
+func (vm *VM) OpCallFn() error {
+	argc := vm.readOperand(code.CALL, 0)
+	vm.need(argc + 1)
+	callee := vm.stack[vm.sp-1-argc]
+	if b, ok := callee.AsObject().(*object.Builtin); ok {
+		return vm.callBuiltin(b, argc)
+	}
+	cl, ok := callee.AsObject().(*object.Closure)
+	if !ok {
+		return fmt.Errorf("can only call functions, got %v", callee)
+	}
+	if argc != cl.Fn.Arity {
+		return fmt.Errorf("function '%s' expects %d arguments, got %d", cl.Fn.Name, cl.Fn.Arity, argc)
+	}
+	if vm.fp >= MAX_FRAMES {
+		return fmt.Errorf("call stack overflow")
+	}
+	vm.pushFrame(NewFrame(cl, vm.sp-argc))
+	return nil
+}
+
+func (vm *VM) OpReturnFn() error {
+	v := vm.pop()
+	f := vm.popFrame()
+	vm.closeUpvalues(f.bp) // captured locals outlive the frame
+	vm.sp = f.bp - 1       // drop the locals and the callee itself
+	vm.push(v)
+	return nil
+}
+
+func (vm *VM) OpLoadBuiltinFn() error {
+	i := vm.readOperand(code.LOAD_BUILTIN, 0)
+	vm.push(object.ObjectValue(object.Builtins[i]))
+	return nil
+}
+
+func (vm *VM) OpLoadUpvalueFn() error {
+	i := vm.readOperand(code.LOAD_UPVALUE, 0)
+	u := vm.currentFrame().cl.Upvalues[i]
+	if u.Closed {
+		vm.push(u.Value)
+	} else {
+		vm.push(vm.stack[u.Slot])
+	}
+	return nil
+}
+
+func (vm *VM) OpStoreUpvalueFn() error {
+	i := vm.readOperand(code.STORE_UPVALUE, 0)
+	u := vm.currentFrame().cl.Upvalues[i]
+	if u.Closed {
+		u.Value = vm.pop()
+	} else {
+		vm.stack[u.Slot] = vm.pop()
+	}
 	return nil
 }
 
+func (vm *VM) OpCloseUpvalueFn() error {
+	vm.closeUpvalues(vm.sp - 1)
+	vm.pop()
+	return nil
+}
+
+func (vm *VM) OpLoadLocal2Fn() error {
+	bp := vm.currentFrame().bp
+	i := vm.readOperand(code.LOAD_LOCAL2, 0)
+	j := vm.readOperand(code.LOAD_LOCAL2, 1)
+	vm.push(vm.stack[bp+i])
+	vm.push(vm.stack[bp+j])
+	return nil
+}
+
+func (vm *VM) OpLoadLocalConstFn() error {
+	i := vm.readOperand(code.LOAD_LOCAL_CONST, 0)
+	k := vm.readOperand(code.LOAD_LOCAL_CONST, 1)
+	vm.push(vm.stack[vm.currentFrame().bp+i])
+	vm.push(vm.co_consts[k])
+	return nil
+}
+
+func (vm *VM) OpLoadConstFn() error {
+	i := vm.readOperand(code.LOAD_CONST, 0)
+	k := vm.readOperand(code.LOAD_CONST, 1)
+	vm.push(vm.co_values[i])
+	vm.push(vm.co_consts[k])
+	return nil
+}
+
+func (vm *VM) OpLoadLocalConstOpFn() error {
+	i := vm.readOperand(code.LOAD_LOCAL_CONST_OP, 0)
+	k := vm.readOperand(code.LOAD_LOCAL_CONST_OP, 1)
+	op := code.Opcode(vm.readOperand(code.LOAD_LOCAL_CONST_OP, 2))
+	vm.push(vm.stack[vm.currentFrame().bp+i])
+	vm.push(vm.co_consts[k])
+	return vm.binary(op)
+}
+
+func (vm *VM) OpLoadConstOpFn() error {
+	i := vm.readOperand(code.LOAD_CONST_OP, 0)
+	k := vm.readOperand(code.LOAD_CONST_OP, 1)
+	op := code.Opcode(vm.readOperand(code.LOAD_CONST_OP, 2))
+	vm.push(vm.co_values[i])
+	vm.push(vm.co_consts[k])
+	return vm.binary(op)
+}
+
 // VIRTUAL MACHINE HELPER FUNCTIONS
-func (vm *VM) popFloat() (float32, float32) {
-	return vm.pop().(float32), vm.pop().(float32)
+
+// makes sure the current frame holds at least n values, a function can
+// never pop the values of its caller
+func (vm *VM) need(n int) {
+	if vm.sp-vm.currentFrame().bp < n {
+		panic(vmPanic{ErrStackUnderflow}) // recovered by Run
+	}
+}
+
+// wraps err with the failing instruction and the active calls
+func (vm *VM) runtimeError(err error, f *Frame, ip int, op code.Opcode) *RuntimeError {
+	e := &RuntimeError{Err: err, Op: op, IP: ip}
+	if f != nil {
+		e.Ln, e.Col = code.LookupLine(f.cl.Fn.Lines, ip)
+	}
+	for i := vm.fp - 1; i >= 0; i-- {
+		fn := vm.frames[i].cl.Fn
+		c := Call{Function: fn.Name, IP: ip}
+		if vm.frames[i] != f {
+			// callers are suspended right after their CALL
+			c.IP = vm.frames[i].ip - code.InstructionSize(code.CALL)
+		}
+		c.Ln, c.Col = code.LookupLine(fn.Lines, c.IP)
+		e.Trace = append(e.Trace, c)
+	}
+	return e
+}
+
+// builtins run straight away, no frame is needed
+func (vm *VM) callBuiltin(b *object.Builtin, argc int) error {
+	args := make([]object.Value, argc)
+	copy(args, vm.stack[vm.sp-argc:vm.sp])
+	v, err := b.Fn(args...)
+	if err != nil {
+		return err
+	}
+	vm.sp -= argc + 1 // drop the arguments and the callee
+	vm.push(v)
+	return nil
+}
+
+// returns the open upvalue for a stack slot, sharing it between closures
+func (vm *VM) captureUpvalue(slot int) *object.Upvalue {
+	for _, u := range vm.upvalues {
+		if u.Slot == slot {
+			return u
+		}
+	}
+	u := &object.Upvalue{Slot: slot}
+	vm.upvalues = append(vm.upvalues, u)
+	return u
+}
+
+// closes every open upvalue pointing at slot last or above
+func (vm *VM) closeUpvalues(last int) {
+	open := vm.upvalues[:0]
+	for _, u := range vm.upvalues {
+		if u.Slot >= last {
+			u.Value = vm.stack[u.Slot]
+			u.Closed = true
+		} else {
+			open = append(open, u)
+		}
+	}
+	vm.upvalues = open
+}
+
+// reads operand n of the current op with the width its definition
+// declares, the operands are read in order
+func (vm *VM) readOperand(op code.Opcode, n int) int {
+	f := vm.currentFrame()
+	w := operandWidths[op][n]
+	i := code.ReadOperand(w, f.Instructions()[f.ip:])
+	f.ip += w
+	return i
+}
+
+// makes sure the n operands of a specialized opcode have the expected type
+func (vm *VM) checkOperands(op code.Opcode, n int) error {
+	vm.need(n)
+	want := operandKinds[op]
+	if want == object.NilKind {
+		return nil // generic opcode
+	}
+	for _, v := range vm.stack[vm.sp-n : vm.sp] {
+		if v.Kind != want {
+			operands := make([]object.Value, n)
+			copy(operands, vm.stack[vm.sp-n:vm.sp])
+			return &TypeError{Op: op, Operands: operands}
+		}
+	}
+	return nil
+}
+
+func (vm *VM) popInt() (int64, int64) {
+	return vm.pop().AsInt(), vm.pop().AsInt()
+}
+
+func (vm *VM) popDecimal() (object.Decimal, object.Decimal) {
+	return vm.pop().AsDecimal(), vm.pop().AsDecimal()
+}
+
+func (vm *VM) popFloat() (float64, float64) {
+	return vm.pop().AsFloat(), vm.pop().AsFloat()
 }
 
 func (vm *VM) popBoolean() (bool, bool) {
-	return vm.pop().(bool), vm.pop().(bool)
+	return vm.pop().AsBool(), vm.pop().AsBool()
 }
 
 func (vm *VM) popString() (string, string) {
-	return vm.pop().(string), vm.pop().(string)
+	return vm.pop().AsString(), vm.pop().AsString()
 }
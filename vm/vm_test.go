@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+	"vmlite/code"
+	"vmlite/object"
+)
+
+// joins the encoded instructions
+func instructions(ins ...[]byte) []byte {
+	out := []byte{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
+
+// runs unverified code, consts[0] is the function 'f' with the given body
+func runCode(main []byte, f []byte) error {
+	fn := &object.CompiledFunction{Name: "f", Instructions: f}
+	consts := []object.Value{object.ObjectValue(fn), object.IntValue(1)}
+	machine := NewVM(main, consts, []string{}, make([]object.Value, 8))
+	return machine.Run()
+}
+
+func TestStackUnderflow(t *testing.T) {
+	tests := []struct {
+		name string
+		main []byte
+		f    []byte
+	}{
+		{"main pops an empty stack", instructions(code.Make(code.POP)), nil},
+		{"binary operator on one value", instructions(code.Make(code.PUSHI, 1), code.Make(code.ADDI)), nil},
+		// the callee finds the caller's 1 and the closure below its frame
+		{"callee pops into its caller", instructions(
+			code.Make(code.PUSHI, 1),
+			code.Make(code.CLOSURE, 0),
+			code.Make(code.CALL, 0),
+			code.Make(code.POP),
+			code.Make(code.POP),
+		), instructions(code.Make(code.POP), code.Make(code.NIL), code.Make(code.RETURN))},
+		{"callee adds its caller's values", instructions(
+			code.Make(code.PUSHI, 1),
+			code.Make(code.PUSHI, 1),
+			code.Make(code.CLOSURE, 0),
+			code.Make(code.CALL, 0),
+		), instructions(code.Make(code.ADD), code.Make(code.RETURN))},
+		{"call without a callee", instructions(code.Make(code.CALL, 2)), nil},
+	}
+	for _, tt := range tests {
+		err := runCode(tt.main, tt.f)
+		var re *RuntimeError
+		if !errors.As(err, &re) || re.Err != ErrStackUnderflow {
+			t.Errorf("%s: got %v, want a stack underflow", tt.name, err)
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	// pushes forever
+	err := runCode(instructions(code.Make(code.PUSHI, 1), code.Make(code.JUMP, 0)), nil)
+	var re *RuntimeError
+	if !errors.As(err, &re) || re.Err != ErrStackOverflow {
+		t.Errorf("got %v, want a stack overflow", err)
+	}
+}
+
+// a Go runtime error is a bug in the VM, Run must not hide it
+func TestRunDoesNotRecoverGoPanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("an index out of range was recovered")
+		}
+	}()
+	runCode(instructions(code.Make(code.LOAD_LOCAL, STACK_SIZE)), nil)
+}
+
+// the constants belong to the Bytecode, the VM must not rewrite them
+func TestNewVMKeepsConstants(t *testing.T) {
+	s := object.StringValue("abc")
+	consts := []object.Value{s}
+	NewVM(instructions(code.Make(code.PUSHS, 0), code.Make(code.PRINT)), consts, []string{}, make([]object.Value, 8))
+	if consts[0].IsInterned() || consts[0].AsObject() != s.AsObject() {
+		t.Errorf("NewVM changed the constant pool")
+	}
+}